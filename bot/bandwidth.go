@@ -0,0 +1,136 @@
+// Copyright 2023 Victor Antonovich <v.antonovich@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bot
+
+import (
+	"context"
+	"io"
+	"math"
+	"sync"
+
+	"golang.org/x/time/rate"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// NewBandwidthLimiter creates upload bandwidth limiter for given limit
+// in bytes per second, returns nil if bandwidth is not limited
+func NewBandwidthLimiter(bytesPerSec uint64) *rate.Limiter {
+	if bytesPerSec == 0 {
+		return nil
+	}
+	burst := bytesPerSec
+	if burst > math.MaxInt32 {
+		burst = math.MaxInt32
+	}
+	return rate.NewLimiter(rate.Limit(bytesPerSec), int(burst))
+}
+
+// limitedFile is a file data uploaded with limited bandwidth,
+// upload is interrupted once context is canceled
+type limitedFile struct {
+	tgbotapi.RequestFileData
+	ctx      context.Context
+	limiters []*rate.Limiter
+	readers  *readers
+}
+
+func (lf limitedFile) UploadData() (string, io.Reader, error) {
+	name, r, err := lf.RequestFileData.UploadData()
+	if err != nil {
+		return name, r, err
+	}
+	lr := &limitedReader{r: r, ctx: lf.ctx, limiters: lf.limiters}
+	lf.readers.add(lr)
+	return name, lr, nil
+}
+
+// readers are readers of uploaded files. Telegram Bot API library closes
+// readers of successfully uploaded files only, so readers are closed once
+// files are sent.
+type readers struct {
+	mu      sync.Mutex
+	closers []io.Closer
+}
+
+func (rs *readers) add(c io.Closer) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.closers = append(rs.closers, c)
+}
+
+func (rs *readers) close() {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	for _, c := range rs.closers {
+		c.Close()
+	}
+	rs.closers = nil
+}
+
+// limitedReader reads data no faster than allowed by all its limiters
+// until context is canceled
+type limitedReader struct {
+	r        io.Reader
+	ctx      context.Context
+	limiters []*rate.Limiter
+}
+
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	if err := lr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	for _, l := range lr.limiters {
+		if len(p) > l.Burst() {
+			p = p[:l.Burst()]
+		}
+	}
+	n, err := lr.r.Read(p)
+	for _, l := range lr.limiters {
+		if werr := l.WaitN(lr.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+func (lr *limitedReader) Close() error {
+	if c, ok := lr.r.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// fileData returns file data to send, with upload bandwidth limited
+// if needed and upload interrupted on context cancel. Uploaded file
+// readers are added to given readers to be closed once file is sent.
+func (b *Bot) fileData(ctx context.Context, f *OutgoingFile, opts SendOptions, rs *readers) tgbotapi.RequestFileData {
+	fd := f.data(b.local)
+	if !fd.NeedsUpload() {
+		return fd
+	}
+	limiters := make([]*rate.Limiter, 0)
+	for _, l := range []*rate.Limiter{b.bandwidthLimiter, opts.Bandwidth} {
+		if l != nil {
+			limiters = append(limiters, l)
+		}
+	}
+	return limitedFile{
+		RequestFileData: fd,
+		ctx:             ctx,
+		limiters:        limiters,
+		readers:         rs,
+	}
+}
@@ -16,9 +16,13 @@ package bot
 
 import (
 	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
 	"path/filepath"
 	"strings"
-	"time"
+	"sync"
 
 	"golang.org/x/time/rate"
 
@@ -34,12 +38,40 @@ type Chat struct {
 type Bot struct {
 	botApi *tgbotapi.BotAPI
 
-	rateLimiter *rate.Limiter
+	local bool
+
+	dryRun bool
+
+	rateLimiter *RateLimiter
+
+	bandwidthLimiter *rate.Limiter
 }
 
-func NewBot(token string) (*Bot, error) {
+type Options struct {
+	// Bot API server URL, default is Telegram Bot API server
+	ApiEndpoint string
+	// Bot API server is running in local mode, so files
+	// are passed to it by local paths instead of uploading
+	Local bool
+	// Upload bandwidth limit in bytes per second, default is no limit
+	Bandwidth uint64
+	// Sent messages rate limiter, default is limiter with default limits
+	RateLimiter *RateLimiter
+	// Print messages would be sent instead of sending them
+	DryRun bool
+}
+
+func NewBot(token string, opts Options) (*Bot, error) {
 	// Create new telegram Bot
-	botApi, err := tgbotapi.NewBotAPI(token)
+	apiEndpoint := tgbotapi.APIEndpoint
+	if opts.ApiEndpoint != "" {
+		apiEndpoint = opts.ApiEndpoint
+		if !strings.Contains(apiEndpoint, "%s") {
+			// Add bot token and method placeholders to server URL
+			apiEndpoint = strings.TrimSuffix(apiEndpoint, "/") + "/bot%s/%s"
+		}
+	}
+	botApi, err := tgbotapi.NewBotAPIWithAPIEndpoint(token, apiEndpoint)
 	if err != nil {
 		return nil, err
 	}
@@ -49,64 +81,380 @@ func NewBot(token string) (*Bot, error) {
 		return nil, err
 	}
 
-	// Create rate limiter (one request per second w/ burst 5 rps)
-	rateLimiter := rate.NewLimiter(rate.Every(time.Second), 5)
+	rateLimiter := opts.RateLimiter
+	if rateLimiter == nil {
+		rateLimiter = NewRateLimiter(DefaultRateLimits)
+	}
 
 	return &Bot{
-		botApi:      botApi,
-		rateLimiter: rateLimiter,
+		botApi:           botApi,
+		local:            opts.Local,
+		dryRun:           opts.DryRun,
+		rateLimiter:      rateLimiter,
+		bandwidthLimiter: NewBandwidthLimiter(opts.Bandwidth),
 	}, nil
 }
 
-func (b *Bot) UploadFile(ctx context.Context, chatId int64, filePath string, document bool, tags ...string) error {
-	glog.V(4).Infof("uploading file %s with tags %v to chat %d", filePath, tags, chatId)
+const (
+	FileKindPhoto     = "photo"
+	FileKindVideo     = "video"
+	FileKindAnimation = "animation"
+	FileKindAudio     = "audio"
+	FileKindDocument  = "document"
 
-	// Convert tags to hashtags
-	ht := strings.Join(tags, " #")
-	if len(ht) > 0 {
-		ht = "#" + ht
+	// Max number of files in media group
+	MaxMediaGroupSize = 10
+)
+
+// File is a file uploaded to Telegram, which could be sent again by its id
+type File struct {
+	Kind string
+	Id   string
+}
+
+// OutgoingFile is a file to send, either local file (or its part) to upload
+// or file already uploaded to Telegram. Once file is sent, it is marked as sent.
+type OutgoingFile struct {
+	Path string
+	Part *FilePart
+	File *File
+	// Original file path, if local file is its converted copy
+	Original string
+	// Send local file as document
+	Document bool
+	Sent     bool
+}
+
+// FilePart is a part of local file to upload as a separate file
+type FilePart struct {
+	Name   string
+	Offset int64
+	Length int64
+}
+
+// SendOptions are options of sending files
+type SendOptions struct {
+	// Send files as documents
+	Document bool
+	// Caption of the first file of every sent media group
+	Caption string
+	// Send files without notification
+	Silent bool
+	// Upload bandwidth limiter, applied in addition to the bot one
+	Bandwidth *rate.Limiter
+	// Tags of files, reported in dry run mode
+	Tags []string
+}
+
+// SendFiles sends files not marked as sent to the chat, uploading local files.
+// Files are sent in media groups when possible, with caption on the first group file.
+// Sent files are marked as sent, uploaded files are set to files sent to Telegram.
+func (b *Bot) SendFiles(ctx context.Context, chatId int64, files []*OutgoingFile, opts SendOptions) error {
+	// Group pending files by compatible kinds in original order
+	groups := make([][]*OutgoingFile, 0)
+	groupIdx := make(map[string]int)
+	for _, f := range files {
+		if f.Sent {
+			continue
+		}
+		kind := f.kind(opts.Document)
+		group := kind
+		if kind == FileKindPhoto || kind == FileKindVideo {
+			// Photos and videos could be mixed in the same media group
+			group = FileKindPhoto + "+" + FileKindVideo
+		}
+		i, ok := groupIdx[group]
+		if !ok || kind == FileKindAnimation || len(groups[i]) == MaxMediaGroupSize {
+			// Animations can't be sent in media groups
+			i = len(groups)
+			groups = append(groups, make([]*OutgoingFile, 0))
+			groupIdx[group] = i
+		}
+		groups[i] = append(groups[i], f)
+	}
+
+	for _, g := range groups {
+		if b.dryRun {
+			dryRunFiles(chatId, g, opts)
+			continue
+		}
+		if err := b.sendGroup(ctx, chatId, g, opts); err != nil {
+			return err
+		}
 	}
 
-	fp := tgbotapi.FilePath(filePath)
-	var m tgbotapi.Chattable
-	if !document {
-		m = b.getMediaMessage(chatId, fp, ht)
+	return nil
+}
+
+func (b *Bot) sendGroup(ctx context.Context, chatId int64, files []*OutgoingFile, opts SendOptions) error {
+	rs := new(readers)
+	defer rs.close()
+
+	if len(files) == 1 {
+		f := files[0]
+		kind := f.kind(opts.Document)
+		glog.V(4).Infof("sending file %s with caption %q to chat %d", f, opts.Caption, chatId)
+		msg, err := b.send(ctx, chatId, newFileMessage(chatId, kind, b.fileData(ctx, f, opts, rs), opts))
+		if err != nil && kind != FileKindDocument && f.File == nil && isMediaRejected(err) {
+			glog.Warningf("%s %s is rejected by Telegram (%v), sending it as document", kind, f, err)
+			df := *f
+			if f.Original != "" {
+				// Send original file instead of its copy converted to fit media limits
+				df.Path = f.Original
+			}
+			msg, err = b.send(ctx, chatId, newFileMessage(chatId, FileKindDocument, b.fileData(ctx, &df, opts, rs), opts))
+		}
+		if err != nil {
+			return err
+		}
+		return f.sent(msg)
 	}
-	if m == nil {
-		m = b.getDocumentMessage(chatId, fp, ht)
+
+	glog.V(4).Infof("sending media group of %d file(s) with caption %q to chat %d", len(files), opts.Caption, chatId)
+	media := make([]interface{}, 0)
+	for i, f := range files {
+		c := ""
+		if i == 0 {
+			c = opts.Caption
+		}
+		media = append(media, newInputMedia(f.kind(opts.Document), b.fileData(ctx, f, opts, rs), c))
 	}
 
-	err := b.rateLimiter.Wait(ctx)
+	// Every media group file is counted as a message
+	err := b.rateLimiter.Wait(ctx, chatId, len(files))
 	if err != nil {
 		return err
 	}
 
-	_, err = b.botApi.Send(m)
+	mg := tgbotapi.NewMediaGroup(chatId, media)
+	mg.DisableNotification = opts.Silent
+	msgs, err := b.botApi.SendMediaGroup(mg)
+	if err != nil && isMediaRejected(err) {
+		// Send group files one by one to send rejected files as documents
+		glog.Warningf("media group is rejected by Telegram (%v), sending its files separately", err)
+		for i, f := range files {
+			fo := opts
+			if i > 0 {
+				fo.Caption = ""
+			}
+			if err := b.sendGroup(ctx, chatId, []*OutgoingFile{f}, fo); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(msgs) != len(files) {
+		return fmt.Errorf("unexpected media group messages count: %d (expected %d)", len(msgs), len(files))
+	}
+	for i, f := range files {
+		if err := f.sent(msgs[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
 
+// SendText sends text message to the chat
+func (b *Bot) SendText(ctx context.Context, chatId int64, text string, opts SendOptions) error {
+	if b.dryRun {
+		dryRunText(chatId, text, opts)
+		return nil
+	}
+	glog.V(4).Infof("sending text message %q to chat %d", text, chatId)
+	m := tgbotapi.NewMessage(chatId, text)
+	m.DisableNotification = opts.Silent
+	_, err := b.send(ctx, chatId, m)
 	return err
 }
 
-func (b *Bot) getMediaMessage(chatId int64, fp tgbotapi.FilePath, caption string) tgbotapi.Chattable {
-	var m tgbotapi.Chattable
-	fn := filepath.Base(string(fp))
+func (b *Bot) send(ctx context.Context, chatId int64, m tgbotapi.Chattable) (tgbotapi.Message, error) {
+	err := b.rateLimiter.Wait(ctx, chatId, 1)
+	if err != nil {
+		return tgbotapi.Message{}, err
+	}
+
+	return b.botApi.Send(m)
+}
+
+func (f *OutgoingFile) String() string {
+	name := f.Path
+	if f.Part != nil {
+		name = fmt.Sprintf("%s (%s)", f.Path, f.Part.Name)
+	}
+	if f.File != nil {
+		return fmt.Sprintf("%s (%s %s)", name, f.File.Kind, f.File.Id)
+	}
+	return name
+}
+
+// kind returns kind of message to send file with
+func (f *OutgoingFile) kind(document bool) string {
+	if f.File != nil {
+		return f.File.Kind
+	}
+	if document || f.Document || f.Part != nil {
+		return FileKindDocument
+	}
+	return MediaKind(f.Path)
+}
+
+func (f *OutgoingFile) data(local bool) tgbotapi.RequestFileData {
+	if f.File != nil {
+		return tgbotapi.FileID(f.File.Id)
+	}
+	if f.Part != nil {
+		return tgbotapi.FileReader{
+			Name:   f.Part.Name,
+			Reader: &partReader{part: f.Part, path: f.Path},
+		}
+	}
+	if local {
+		// Local Bot API server reads files by URI
+		if p, err := filepath.Abs(f.Path); err == nil {
+			return tgbotapi.FileURL((&url.URL{Scheme: "file", Path: p}).String())
+		}
+	}
+	return tgbotapi.FilePath(f.Path)
+}
+
+// sent marks file as sent with the message
+func (f *OutgoingFile) sent(msg tgbotapi.Message) error {
+	if f.File == nil {
+		f.File = sentFile(msg)
+		if f.File == nil {
+			return fmt.Errorf("no file in sent message")
+		}
+	}
+	f.Sent = true
+	return nil
+}
+
+// MediaKind returns kind of media message to send file with by file extension
+func MediaKind(filePath string) string {
+	fn := filepath.Base(filePath)
 	if util.IsFileExtensionMatched(fn, "mp3", "m4a") {
-		am := tgbotapi.NewAudio(chatId, fp)
-		am.Caption = caption
-		m = am
+		return FileKindAudio
 	} else if util.IsFileExtensionMatched(fn, "mp4") {
-		vm := tgbotapi.NewVideo(chatId, fp)
-		vm.Caption = caption
-		m = vm
+		return FileKindVideo
 	} else if util.IsFileExtensionMatched(fn, "jpg", "jpeg", "png", "gif") {
-		pm := tgbotapi.NewPhoto(chatId, fp)
-		pm.Caption = caption
-		m = pm
+		return FileKindPhoto
 	}
-	return m
+	return FileKindDocument
 }
 
-func (b *Bot) getDocumentMessage(chatId int64, fp tgbotapi.FilePath, caption string) tgbotapi.Chattable {
-	dm := tgbotapi.NewDocument(chatId, fp)
+func newFileMessage(chatId int64, kind string, fd tgbotapi.RequestFileData, opts SendOptions) tgbotapi.Chattable {
+	switch kind {
+	case FileKindPhoto:
+		pm := tgbotapi.NewPhoto(chatId, fd)
+		pm.Caption = opts.Caption
+		pm.DisableNotification = opts.Silent
+		return pm
+	case FileKindVideo:
+		vm := tgbotapi.NewVideo(chatId, fd)
+		vm.Caption = opts.Caption
+		vm.DisableNotification = opts.Silent
+		return vm
+	case FileKindAnimation:
+		am := tgbotapi.NewAnimation(chatId, fd)
+		am.Caption = opts.Caption
+		am.DisableNotification = opts.Silent
+		return am
+	case FileKindAudio:
+		am := tgbotapi.NewAudio(chatId, fd)
+		am.Caption = opts.Caption
+		am.DisableNotification = opts.Silent
+		return am
+	}
+	dm := tgbotapi.NewDocument(chatId, fd)
+	dm.Caption = opts.Caption
+	dm.DisableNotification = opts.Silent
+	return dm
+}
+
+func newInputMedia(kind string, fd tgbotapi.RequestFileData, caption string) interface{} {
+	switch kind {
+	case FileKindPhoto:
+		pm := tgbotapi.NewInputMediaPhoto(fd)
+		pm.Caption = caption
+		return pm
+	case FileKindVideo:
+		vm := tgbotapi.NewInputMediaVideo(fd)
+		vm.Caption = caption
+		return vm
+	case FileKindAudio:
+		am := tgbotapi.NewInputMediaAudio(fd)
+		am.Caption = caption
+		return am
+	}
+	dm := tgbotapi.NewInputMediaDocument(fd)
 	dm.Caption = caption
 	return dm
 }
+
+// sentFile returns file sent with the message
+func sentFile(msg tgbotapi.Message) *File {
+	switch {
+	case len(msg.Photo) > 0:
+		// Photo sizes are sorted in ascending order
+		return &File{Kind: FileKindPhoto, Id: msg.Photo[len(msg.Photo)-1].FileID}
+	case msg.Video != nil:
+		return &File{Kind: FileKindVideo, Id: msg.Video.FileID}
+	case msg.Animation != nil:
+		return &File{Kind: FileKindAnimation, Id: msg.Animation.FileID}
+	case msg.Audio != nil:
+		return &File{Kind: FileKindAudio, Id: msg.Audio.FileID}
+	case msg.Document != nil:
+		return &File{Kind: FileKindDocument, Id: msg.Document.FileID}
+	}
+	return nil
+}
+
+// Hashtags converts tags to hashtags
+func Hashtags(tags []string) string {
+	ht := strings.Join(tags, " #")
+	if len(ht) > 0 {
+		ht = "#" + ht
+	}
+	return ht
+}
+
+// partReader reads file part, opening file on first read
+// and closing it when read is done
+type partReader struct {
+	part *FilePart
+	path string
+	f    *os.File
+	r    io.Reader
+	mu   sync.Mutex // reader could be closed while being read on failed upload
+}
+
+func (pr *partReader) Read(p []byte) (int, error) {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+
+	if pr.r == nil {
+		f, err := os.Open(pr.path)
+		if err != nil {
+			return 0, err
+		}
+		pr.f = f
+		pr.r = io.NewSectionReader(f, pr.part.Offset, pr.part.Length)
+	}
+	return pr.r.Read(p)
+}
+
+func (pr *partReader) Close() error {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+
+	if pr.f == nil {
+		return nil
+	}
+	err := pr.f.Close()
+	pr.f = nil
+	return err
+}
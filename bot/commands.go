@@ -0,0 +1,156 @@
+// Copyright 2023 Victor Antonovich <v.antonovich@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bot
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/golang/glog"
+)
+
+const (
+	// Long polling timeout of getting updates, in seconds
+	UpdatesTimeout = 30
+	// Delay of getting updates after error
+	UpdatesRetryDelay = 5 * time.Second
+)
+
+// Command is a bot command received from chat
+type Command struct {
+	// Id of update command is received with
+	UpdateId int
+	ChatId   int64
+	UserId   int64
+	// Command name without leading slash and bot username
+	Name string
+	// Command arguments
+	Args string
+}
+
+// Commands receives bot commands sent to chats until context is canceled,
+// starting from update with given id. Received updates are confirmed as
+// soon as commands are taken from returned channel, so the same commands
+// are not received again by the next call. Returned channel is closed
+// once receiving is stopped.
+func (b *Bot) Commands(ctx context.Context, offset int) <-chan Command {
+	cmdCh := make(chan Command)
+
+	go func() {
+		defer close(cmdCh)
+
+		glog.V(2).Infoln("receiving bot commands")
+		defer glog.V(2).Infoln("receiving bot commands stopped")
+
+		// Updates are confirmed by getting updates with greater offset,
+		// so updates are got without waiting once some are received
+		timeout := UpdatesTimeout
+		defer func() { b.confirmUpdates(offset) }()
+		for ctx.Err() == nil {
+			updates, err := b.botApi.GetUpdates(tgbotapi.UpdateConfig{
+				Offset:         offset,
+				Timeout:        timeout,
+				AllowedUpdates: []string{"message"},
+			})
+			if err != nil {
+				glog.Warningf("can't get bot updates: %v", err)
+				select {
+				case <-time.After(UpdatesRetryDelay):
+				case <-ctx.Done():
+				}
+				continue
+			}
+			timeout = UpdatesTimeout
+			if len(updates) > 0 {
+				timeout = 0
+			}
+			for _, u := range updates {
+				if cmd, ok := b.command(u); ok {
+					select {
+					case cmdCh <- cmd:
+					case <-ctx.Done():
+						return
+					}
+				}
+				offset = u.UpdateID + 1
+			}
+		}
+	}()
+
+	return cmdCh
+}
+
+// command returns command received with update, if it's sent to the bot
+func (b *Bot) command(u tgbotapi.Update) (Command, bool) {
+	m := u.Message
+	if m == nil || !m.IsCommand() {
+		return Command{}, false
+	}
+	if name := m.CommandWithAt(); strings.Contains(name, "@") &&
+		!strings.EqualFold(name[strings.Index(name, "@")+1:], b.botApi.Self.UserName) {
+		// Command is sent to another bot
+		return Command{}, false
+	}
+	cmd := Command{
+		UpdateId: u.UpdateID,
+		ChatId:   m.Chat.ID,
+		Name:     m.Command(),
+		Args:     m.CommandArguments(),
+	}
+	if m.From != nil {
+		cmd.UserId = m.From.ID
+	}
+	return cmd, true
+}
+
+// confirmUpdates confirms updates with ids less than offset are received
+func (b *Bot) confirmUpdates(offset int) {
+	if offset == 0 {
+		return
+	}
+	_, err := b.botApi.GetUpdates(tgbotapi.UpdateConfig{
+		Offset:         offset,
+		Limit:          1,
+		AllowedUpdates: []string{"message"},
+	})
+	if err != nil {
+		glog.Warningf("can't confirm bot updates: %v", err)
+	}
+}
+
+// Id returns bot user id
+func (b *Bot) Id() int64 {
+	return b.botApi.Self.ID
+}
+
+// IsChatAdmin checks user is the chat creator or administrator
+func (b *Bot) IsChatAdmin(chatId, userId int64) (bool, error) {
+	if chatId > 0 {
+		// Private chats have no administrators
+		return false, nil
+	}
+	m, err := b.botApi.GetChatMember(tgbotapi.GetChatMemberConfig{
+		ChatConfigWithUser: tgbotapi.ChatConfigWithUser{
+			ChatID: chatId,
+			UserID: userId,
+		},
+	})
+	if err != nil {
+		return false, err
+	}
+	return m.IsCreator() || m.IsAdministrator(), nil
+}
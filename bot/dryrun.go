@@ -0,0 +1,74 @@
+// Copyright 2023 Victor Antonovich <v.antonovich@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bot
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/golang/glog"
+)
+
+// dryRunMessage describes message would be sent in dry run mode
+type dryRunMessage struct {
+	Chat    int64    `json:"chat"`
+	Method  string   `json:"method"`
+	Files   []string `json:"files,omitempty"`
+	Caption string   `json:"caption,omitempty"`
+	Text    string   `json:"text,omitempty"`
+	Tags    []string `json:"tags,omitempty"`
+	Silent  bool     `json:"silent,omitempty"`
+}
+
+// dryRunFiles prints files would be sent to the chat as JSON line
+// and marks files as sent
+func dryRunFiles(chatId int64, files []*OutgoingFile, opts SendOptions) {
+	m := &dryRunMessage{
+		Chat:    chatId,
+		Method:  "sendMediaGroup",
+		Caption: opts.Caption,
+		Tags:    opts.Tags,
+		Silent:  opts.Silent,
+	}
+	if len(files) == 1 {
+		kind := files[0].kind(opts.Document)
+		m.Method = "send" + strings.ToUpper(kind[:1]) + kind[1:]
+	}
+	for _, f := range files {
+		m.Files = append(m.Files, f.String())
+		f.Sent = true
+	}
+	dryRun(m)
+}
+
+// dryRunText prints text message would be sent to the chat as JSON line
+func dryRunText(chatId int64, text string, opts SendOptions) {
+	dryRun(&dryRunMessage{
+		Chat:   chatId,
+		Method: "sendMessage",
+		Text:   text,
+		Silent: opts.Silent,
+	})
+}
+
+func dryRun(m *dryRunMessage) {
+	line, err := json.Marshal(m)
+	if err != nil {
+		glog.Errorf("can't encode dry run message: %v", err)
+		return
+	}
+	fmt.Println(string(line))
+}
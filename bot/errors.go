@@ -0,0 +1,107 @@
+// Copyright 2023 Victor Antonovich <v.antonovich@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bot
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"net/http"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// IsTransientError checks error returned by bot could go away if request is repeated.
+// For transient errors it also returns delay requested by Telegram before next
+// request (or zero if delay is not requested).
+func IsTransientError(err error) (bool, time.Duration) {
+	if err == nil {
+		return false, 0
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false, 0
+	}
+	// File to upload is gone or not readable
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		return false, 0
+	}
+
+	var apiErr *tgbotapi.Error
+	if !errors.As(err, &apiErr) {
+		// Network or response decoding error
+		return true, 0
+	}
+	if apiErr.RetryAfter > 0 {
+		return true, time.Duration(apiErr.RetryAfter) * time.Second
+	}
+	code := apiErr.Code
+	if code == 0 {
+		// Error code is not set for file uploads, so guess it by description
+		code = errorCodeByDescription(apiErr.Message)
+	}
+	return code == http.StatusTooManyRequests || code >= http.StatusInternalServerError, 0
+}
+
+func errorCodeByDescription(description string) int {
+	for _, code := range []int{
+		http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout,
+	} {
+		if strings.HasPrefix(description, http.StatusText(code)) {
+			return code
+		}
+	}
+	return 0
+}
+
+// Telegram error descriptions for media files not accepted as photo, video etc.
+var mediaRejectedErrors = []string{
+	"PHOTO_INVALID_DIMENSIONS",
+	"PHOTO_EXT_INVALID",
+	"PHOTO_SAVE_FILE_INVALID",
+	"PHOTO_INVALID",
+	"IMAGE_PROCESS_FAILED",
+	"VIDEO_FILE_INVALID",
+	"AUDIO_FILE_INVALID",
+	"MEDIA_INVALID",
+	"MEDIA_EMPTY",
+	"WRONG FILE TYPE",
+	"WRONG TYPE OF THE WEB PAGE CONTENT",
+	"FAILED TO GET HTTP URL CONTENT",
+	"WEBPAGE_CURL_FAILED",
+	"WEBPAGE_MEDIA_EMPTY",
+}
+
+// isMediaRejected checks error is caused by media file rejected by Telegram,
+// so file could be sent as a document instead
+func isMediaRejected(err error) bool {
+	var apiErr *tgbotapi.Error
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	description := strings.ToUpper(apiErr.Message)
+	for _, e := range mediaRejectedErrors {
+		if strings.Contains(description, e) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,131 @@
+// Copyright 2023 Victor Antonovich <v.antonovich@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bot
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// RateLimit is a rate of messages allowed to send with max burst
+type RateLimit struct {
+	Limit rate.Limit
+	Burst int
+}
+
+// RateLimits are limits of messages sent by bot to all chats
+// and to every group (or channel) and private chat
+type RateLimits struct {
+	Global  RateLimit
+	Group   RateLimit
+	Private RateLimit
+}
+
+// Default rate limits follow Telegram Bot API limits: no more than 30 messages
+// per second overall, one message per second to the same chat and 20 messages
+// per minute to the same group
+var DefaultRateLimits = RateLimits{
+	Global:  RateLimit{Limit: 30, Burst: 5},
+	Group:   RateLimit{Limit: rate.Every(time.Minute / 20), Burst: 1},
+	Private: RateLimit{Limit: 1, Burst: 1},
+}
+
+// RateLimiter limits messages sent by bot globally and per chat. Limiter
+// could be shared by bots to keep its state when bot is recreated.
+type RateLimiter struct {
+	limits RateLimits
+	global *rate.Limiter
+	chats  map[int64]*rate.Limiter
+	mu     sync.Mutex
+}
+
+func NewRateLimiter(limits RateLimits) *RateLimiter {
+	return &RateLimiter{
+		limits: limits,
+		global: rate.NewLimiter(limits.Global.Limit, limits.Global.Burst),
+		chats:  make(map[int64]*rate.Limiter),
+	}
+}
+
+// SetLimits changes limits, keeping state of existing limiters
+func (rl *RateLimiter) SetLimits(limits RateLimits) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	rl.limits = limits
+	setLimit(rl.global, limits.Global)
+	for chatId, l := range rl.chats {
+		setLimit(l, rl.chatLimit(chatId))
+	}
+}
+
+// Wait waits until n messages could be sent to the chat
+func (rl *RateLimiter) Wait(ctx context.Context, chatId int64, n int) error {
+	rl.mu.Lock()
+	cl, ok := rl.chats[chatId]
+	if !ok {
+		limit := rl.chatLimit(chatId)
+		cl = rate.NewLimiter(limit.Limit, limit.Burst)
+		rl.chats[chatId] = cl
+	}
+	rl.mu.Unlock()
+
+	for _, l := range []*rate.Limiter{cl, rl.global} {
+		if err := waitN(ctx, l, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// waitN waits for n limiter tokens, taking at most burst tokens at once,
+// so a media group of more files than burst is counted as all its files
+func waitN(ctx context.Context, l *rate.Limiter, n int) error {
+	for n > 0 {
+		k := minInt(n, l.Burst())
+		if k < 1 {
+			k = 1
+		}
+		if err := l.WaitN(ctx, k); err != nil {
+			return err
+		}
+		n -= k
+	}
+	return nil
+}
+
+// chatLimit returns limit of messages sent to the chat, mutex must be held.
+// Group and channel ids are negative, private chat ids are positive.
+func (rl *RateLimiter) chatLimit(chatId int64) RateLimit {
+	if chatId < 0 {
+		return rl.limits.Group
+	}
+	return rl.limits.Private
+}
+
+func setLimit(l *rate.Limiter, limit RateLimit) {
+	l.SetLimit(limit.Limit)
+	l.SetBurst(limit.Burst)
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
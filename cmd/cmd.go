@@ -21,6 +21,7 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
 
 	"github.com/golang/glog"
@@ -31,6 +32,8 @@ import (
 	"github.com/3cky/telegram-uploader-bot/build"
 	"github.com/3cky/telegram-uploader-bot/config"
 	"github.com/3cky/telegram-uploader-bot/log"
+	"github.com/3cky/telegram-uploader-bot/queue"
+	"github.com/3cky/telegram-uploader-bot/store"
 	uploaderpkg "github.com/3cky/telegram-uploader-bot/uploader"
 )
 
@@ -38,6 +41,9 @@ const (
 	FlagVersion = "version"
 	FlagHelpMd  = "help-md"
 	FlagConfig  = "config"
+	FlagDryRun  = "dry-run"
+
+	StateFileName = "state.db"
 )
 
 func NewCmd() *cobra.Command {
@@ -59,6 +65,7 @@ func initCmd(cmd *cobra.Command) {
 	f.Bool(FlagVersion, false, "display the version number and build timestamp")
 	f.Bool(FlagHelpMd, false, "get help in Markdown format")
 	f.StringVarP(&config.ConfigFile, FlagConfig, "c", config.DefaultConfigFile, "config file")
+	f.Bool(FlagDryRun, false, "print messages would be sent as JSON lines instead of sending them")
 
 	pflag.CommandLine.AddFlagSet(f)
 	pflag.CommandLine.AddGoFlagSet(flag.CommandLine)
@@ -92,6 +99,7 @@ func runCmd(cmd *cobra.Command, _ []string) {
 		if err != nil {
 			return nil, err
 		}
+		config.Telegram.DryRun, _ = cmd.Flags().GetBool(FlagDryRun)
 		return config, nil
 	}
 
@@ -101,9 +109,38 @@ func runCmd(cmd *cobra.Command, _ []string) {
 		return
 	}
 
+	// Open persistent state store, if configured. Dry run doesn't change
+	// the store, so it could be run alongside the bot using the same store.
+	stateDir := config.StateDir
+	dryRun := config.Telegram.DryRun
+	var st *store.Store
+	if stateDir != "" {
+		if dryRun {
+			st, err = store.OpenReadOnly(filepath.Join(stateDir, StateFileName))
+		} else {
+			st, err = store.Open(filepath.Join(stateDir, StateFileName))
+		}
+		if err != nil {
+			glog.Errorf("state store open error: %v, exiting...", err)
+			return
+		}
+		defer st.Close()
+	}
+
+	// Create upload queue, files queued by the bot are not taken by dry run
+	qst := st
+	if dryRun {
+		qst = nil
+	}
+	q, err := queue.New(qst)
+	if err != nil {
+		glog.Errorf("upload queue open error: %v, exiting...", err)
+		return
+	}
+
 	ctx := context.Background()
 
-	uploader, err := uploaderpkg.NewUploader(ctx, config)
+	uploader, err := uploaderpkg.NewUploader(ctx, config, st, q)
 	if err != nil {
 		glog.Errorf("config couldn't be used: %v", err)
 		return
@@ -129,6 +166,13 @@ func runCmd(cmd *cobra.Command, _ []string) {
 			glog.V(2).Infof("received %v signal", sig)
 			// Stop files uploading and exit
 			uploader.Stop()
+			if n := q.Len(); n > 0 {
+				if qst != nil {
+					glog.Infof("%d file(s) left in upload queue to be uploaded on next start", n)
+				} else if !dryRun {
+					glog.Warningf("%d file(s) left in upload queue are dropped as state directory is not set", n)
+				}
+			}
 			return
 		case syscall.SIGHUP:
 			glog.V(2).Infof("received %v signal, reloading config", sig)
@@ -137,16 +181,13 @@ func runCmd(cmd *cobra.Command, _ []string) {
 				glog.Errorf("config reloading error: %v", err)
 				continue
 			}
-			newUploader, err := uploaderpkg.NewUploader(ctx, config)
-			if err != nil {
+			if config.StateDir != stateDir {
+				glog.Warningf("state directory change requires restart, still using: %s", stateDir)
+			}
+			// Apply config changes to running uploader
+			if err := uploader.Reload(config); err != nil {
 				glog.Errorf("reloaded config can't be used: %v", err)
-				continue
 			}
-			// Stop files uploading using current config
-			uploader.Stop()
-			// Start files uploading using new config
-			uploader = newUploader
-			go uploader.Start()
 		}
 	}
 }
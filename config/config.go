@@ -16,6 +16,7 @@ package config
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/c2h5oh/datasize"
 	"github.com/golang/glog"
@@ -33,22 +34,89 @@ const (
 var ConfigFile string
 
 type Config struct {
-	Telegram Telegram
-	Uploads  []Upload
+	StateDir        string `mapstructure:"state_dir"`
+	Workers         int
+	ShutdownTimeout time.Duration `mapstructure:"shutdown_timeout"`
+	Telegram        Telegram
+	Retry           Retry
+	Uploads         []Upload
 }
 
 type Telegram struct {
-	Token string
+	Token       string
+	ApiEndpoint string `mapstructure:"api_endpoint"`
+	Local       bool
+	Bandwidth   datasize.ByteSize
+	RateLimit   RateLimits `mapstructure:"rate_limit"`
+	Commands    bool
+	Admins      []int64
+	ChatAdmins  bool `mapstructure:"chat_admins"`
+	DryRun      bool `mapstructure:"-"` // set by command line flag
+}
+
+type RateLimits struct {
+	Global  RateLimit
+	Group   RateLimit
+	Private RateLimit
+}
+
+type RateLimit struct {
+	Messages int
+	Per      time.Duration
+	Burst    int
 }
 
 type Upload struct {
-	Directory    string
-	FilePatterns []string          `mapstructure:"files"`
-	MinSize      datasize.ByteSize `mapstructure:"min_size"`
-	MaxSize      datasize.ByteSize `mapstructure:"max_size"`
-	ChatId       int64             `mapstructure:"chat"`
-	Document     bool
-	Tags         Tags
+	Directory       string
+	Recursive       bool
+	WatchMode       string        `mapstructure:"watch_mode"`
+	PollInterval    time.Duration `mapstructure:"poll_interval"`
+	Settle          time.Duration
+	FilePatterns    []string `mapstructure:"files"`
+	ExcludePatterns []string `mapstructure:"exclude"`
+	Backfill        bool
+	BackfillMaxAge  time.Duration     `mapstructure:"backfill_max_age"`
+	MinSize         datasize.ByteSize `mapstructure:"min_size"`
+	MaxSize         datasize.ByteSize `mapstructure:"max_size"`
+	Split           bool
+	Bandwidth       datasize.ByteSize
+	ChatId          int64   `mapstructure:"chat"`
+	ChatIds         []int64 `mapstructure:"chats"`
+	Document        bool
+	Photo           Photo
+	Album           time.Duration
+	UploadWindow    string `mapstructure:"upload_window"`
+	Timezone        string
+	OutsideWindow   string `mapstructure:"outside_window"`
+	Dedupe          bool
+	Digest          *Digest
+	Tags            Tags
+	Retry           *Retry
+	OnSuccess       *Action `mapstructure:"on_success"`
+	OnFailure       *Action `mapstructure:"on_failure"`
+}
+
+type Photo struct {
+	MaxDimension int `mapstructure:"max_dimension"`
+	JpegQuality  int `mapstructure:"jpeg_quality"`
+}
+
+type Digest struct {
+	Interval time.Duration
+	Format   string
+}
+
+type Retry struct {
+	Attempts int
+	Delay    time.Duration
+	MaxDelay time.Duration `mapstructure:"max_delay"`
+}
+
+type Action struct {
+	Action     string
+	Directory  string
+	DateSubdir string `mapstructure:"date_subdir"`
+	Suffix     string
 }
 
 type Tags struct {
@@ -70,7 +138,10 @@ func NewConfig(cmd *cobra.Command) (*Config, error) {
 	// Parse config
 	err = viper.Unmarshal(&config, func(m *mapstructure.DecoderConfig) {
 		m.ErrorUnused = true
-	}, viper.DecodeHook(mapstructure.TextUnmarshallerHookFunc()))
+	}, viper.DecodeHook(mapstructure.ComposeDecodeHookFunc(
+		mapstructure.TextUnmarshallerHookFunc(),
+		mapstructure.StringToTimeDurationHookFunc(),
+	)))
 	if err != nil {
 		return nil, err
 	}
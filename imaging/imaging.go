@@ -0,0 +1,240 @@
+// Copyright 2023 Victor Antonovich <v.antonovich@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package imaging
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"io"
+	"os"
+
+	// Register supported image formats
+	_ "image/gif"
+	_ "image/png"
+)
+
+const (
+	// Telegram photo constraints
+	MaxPhotoSize          = 10 * 1024 * 1024
+	MaxPhotoDimensionsSum = 10000
+	MaxPhotoAspectRatio   = 20
+
+	DefaultJpegQuality = 90
+
+	// Max attempts to fit encoded photo to max size
+	maxEncodeAttempts = 4
+)
+
+// ErrAspectRatio is returned for image can't fit the limits by downscaling
+var ErrAspectRatio = errors.New("image aspect ratio exceeds limit")
+
+// Limits are photo size and dimension limits
+type Limits struct {
+	// Max photo width and height, zero for no limit
+	MaxDimension int
+	// Max photo file size
+	MaxSize int64
+	// Max photo width and height sum
+	MaxDimensionsSum int
+	// Max ratio of photo longer side to shorter one, zero for no limit
+	MaxAspectRatio int
+}
+
+// TelegramLimits returns Telegram photo limits with given max dimension
+func TelegramLimits(maxDimension int) Limits {
+	return Limits{
+		MaxDimension:     maxDimension,
+		MaxSize:          MaxPhotoSize,
+		MaxDimensionsSum: MaxPhotoDimensionsSum,
+		MaxAspectRatio:   MaxPhotoAspectRatio,
+	}
+}
+
+// FitsLimits checks image file fits the limits. ErrAspectRatio
+// is returned if image aspect ratio exceeds the limit.
+func FitsLimits(path string, l Limits) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+
+	c, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return false, err
+	}
+	if !fitsAspectRatio(c.Width, c.Height, l) {
+		return false, ErrAspectRatio
+	}
+
+	return fi.Size() <= l.MaxSize && scale(c.Width, c.Height, l) == 1, nil
+}
+
+// FitToJpeg downscales image file to fit the limits and saves it to
+// a new temporary JPEG file, returning its path. JPEG image is rotated
+// according to its EXIF orientation, as EXIF data is not saved.
+func FitToJpeg(path string, l Limits, quality int) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	src, format, err := image.Decode(f)
+	if err != nil {
+		return "", err
+	}
+	orientation := 1
+	if format == "jpeg" {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return "", err
+		}
+		orientation = jpegOrientation(f)
+	}
+
+	b := src.Bounds()
+	if !fitsAspectRatio(b.Dx(), b.Dy(), l) {
+		return "", ErrAspectRatio
+	}
+	s := scale(b.Dx(), b.Dy(), l)
+	for attempt := 1; ; attempt++ {
+		dst := orient(downscale(src, int(float64(b.Dx())*s), int(float64(b.Dy())*s)), orientation)
+		tmpPath, size, err := saveJpeg(dst, quality)
+		if err != nil {
+			return "", err
+		}
+		if size <= l.MaxSize {
+			return tmpPath, nil
+		}
+		os.Remove(tmpPath)
+		if attempt == maxEncodeAttempts {
+			return "", fmt.Errorf("can't fit image to %d bytes", l.MaxSize)
+		}
+		// Encoded image is still too big, reduce its size further
+		s *= 0.75
+	}
+}
+
+// fitsAspectRatio checks image aspect ratio fits the limits
+func fitsAspectRatio(w, h int, l Limits) bool {
+	if l.MaxAspectRatio == 0 {
+		return true
+	}
+	long, short := maxInt(w, h), minInt(w, h)
+	return short > 0 && long <= short*l.MaxAspectRatio
+}
+
+// scale returns image scale factor to fit the limits
+func scale(w, h int, l Limits) float64 {
+	s := 1.0
+	if l.MaxDimension > 0 && (w > l.MaxDimension || h > l.MaxDimension) {
+		s = float64(l.MaxDimension) / float64(maxInt(w, h))
+	}
+	if l.MaxDimensionsSum > 0 && float64(w+h)*s > float64(l.MaxDimensionsSum) {
+		s = float64(l.MaxDimensionsSum) / float64(w+h)
+	}
+	return s
+}
+
+func saveJpeg(img image.Image, quality int) (string, int64, error) {
+	f, err := os.CreateTemp("", "telegram-uploader-bot-*.jpg")
+	if err != nil {
+		return "", 0, err
+	}
+	err = jpeg.Encode(f, img, &jpeg.Options{Quality: quality})
+	if err == nil {
+		err = f.Sync()
+	}
+	var size int64
+	if err == nil {
+		var fi os.FileInfo
+		fi, err = f.Stat()
+		if fi != nil {
+			size = fi.Size()
+		}
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", 0, err
+	}
+	return f.Name(), size, nil
+}
+
+// downscale resizes image to given size by area averaging,
+// transparent areas are blended with white background
+func downscale(src image.Image, w, h int) *image.RGBA {
+	b := src.Bounds()
+	w, h = maxInt(w, 1), maxInt(h, 1)
+
+	// Convert source image to RGBA to access its pixels directly
+	rgba, ok := src.(*image.RGBA)
+	if !ok || b.Min != (image.Point{}) {
+		rgba = image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+		draw.Draw(rgba, rgba.Bounds(), src, b.Min, draw.Src)
+	}
+	sw, sh := rgba.Bounds().Dx(), rgba.Bounds().Dy()
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		sy0, sy1 := y*sh/h, maxInt((y+1)*sh/h, y*sh/h+1)
+		for x := 0; x < w; x++ {
+			sx0, sx1 := x*sw/w, maxInt((x+1)*sw/w, x*sw/w+1)
+			var r, g, bl, a, n uint64
+			for sy := sy0; sy < sy1; sy++ {
+				i := rgba.PixOffset(sx0, sy)
+				for sx := sx0; sx < sx1; sx++ {
+					r += uint64(rgba.Pix[i])
+					g += uint64(rgba.Pix[i+1])
+					bl += uint64(rgba.Pix[i+2])
+					a += uint64(rgba.Pix[i+3])
+					n++
+					i += 4
+				}
+			}
+			// Colors are alpha-premultiplied, so add white for transparency
+			white := 255*n - a
+			i := dst.PixOffset(x, y)
+			dst.Pix[i] = uint8((r + white) / n)
+			dst.Pix[i+1] = uint8((g + white) / n)
+			dst.Pix[i+2] = uint8((bl + white) / n)
+			dst.Pix[i+3] = 255
+		}
+	}
+	return dst
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,227 @@
+// Copyright 2023 Victor Antonovich <v.antonovich@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/golang/glog"
+
+	"github.com/3cky/telegram-uploader-bot/store"
+)
+
+// Store key prefix for queued items
+const keyPrefix = "queue/"
+
+// Item is a file queued for upload.
+type Item struct {
+	Seq  uint64 `json:"-"`
+	Task string `json:"task"`
+	Path string `json:"path"`
+	// Chats to upload file to, all task chats if empty
+	Chats []int64 `json:"chats,omitempty"`
+}
+
+// Queue is an unbounded FIFO queue of files to upload. If backed by store,
+// items are persisted until acknowledged, so items popped but not acknowledged
+// before restart are queued again.
+type Queue struct {
+	mu sync.Mutex
+
+	store *store.Store
+
+	seq      uint64
+	items    []*Item
+	inFlight map[uint64]*Item
+
+	notifyCh chan struct{}
+}
+
+// New creates queue backed by given store. Store could be nil,
+// so queue is kept in memory only.
+func New(st *store.Store) (*Queue, error) {
+	q := &Queue{
+		store:    st,
+		items:    make([]*Item, 0),
+		inFlight: make(map[uint64]*Item),
+		notifyCh: make(chan struct{}, 1),
+	}
+
+	if st == nil {
+		return q, nil
+	}
+
+	// Load persisted items, keys are sorted in queue order
+	for _, k := range st.Keys(keyPrefix) {
+		seq, err := strconv.ParseUint(strings.TrimPrefix(k, keyPrefix), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("malformed queue item key %s: %v", k, err)
+		}
+		v, _ := st.Get(k)
+		item := new(Item)
+		if err := json.Unmarshal([]byte(v), item); err != nil {
+			glog.Warningf("skipping malformed queue item %s: %v", k, err)
+			continue
+		}
+		item.Seq = seq
+		q.items = append(q.items, item)
+		q.seq = seq
+	}
+
+	if len(q.items) > 0 {
+		glog.V(1).Infof("resuming %d queued upload(s)", len(q.items))
+		q.notify()
+	}
+
+	return q, nil
+}
+
+// Push adds item to the queue tail. Item file is uploaded
+// to given chats only, if set.
+func (q *Queue) Push(task, path string, chats ...int64) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.seq++
+	item := &Item{
+		Seq:   q.seq,
+		Task:  task,
+		Path:  path,
+		Chats: chats,
+	}
+
+	if q.store != nil {
+		b, err := json.Marshal(item)
+		if err != nil {
+			return err
+		}
+		if err := q.store.Put(itemKey(item.Seq), string(b)); err != nil {
+			return err
+		}
+	}
+
+	q.items = append(q.items, item)
+	q.notify()
+
+	return nil
+}
+
+// Pop removes item from the queue head, waiting for it if queue is empty.
+// Popped item must be either acknowledged or released.
+func (q *Queue) Pop(ctx context.Context) (*Item, error) {
+	for {
+		q.mu.Lock()
+		if len(q.items) > 0 {
+			item := q.items[0]
+			q.items[0] = nil
+			q.items = q.items[1:]
+			q.inFlight[item.Seq] = item
+			if len(q.items) > 0 {
+				q.notify()
+			}
+			q.mu.Unlock()
+			return item, nil
+		}
+		q.mu.Unlock()
+
+		select {
+		case <-q.notifyCh:
+			continue
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Ack removes popped item from the queue permanently.
+func (q *Queue) Ack(item *Item) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	delete(q.inFlight, item.Seq)
+
+	if q.store != nil {
+		return q.store.Delete(itemKey(item.Seq))
+	}
+
+	return nil
+}
+
+// Release returns popped item back to the queue head.
+func (q *Queue) Release(item *Item) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, ok := q.inFlight[item.Seq]; !ok {
+		return
+	}
+	delete(q.inFlight, item.Seq)
+
+	// Keep items order
+	i := 0
+	for i < len(q.items) && q.items[i].Seq < item.Seq {
+		i++
+	}
+	q.items = append(q.items, nil)
+	copy(q.items[i+1:], q.items[i:])
+	q.items[i] = item
+
+	q.notify()
+}
+
+// Len returns number of queued and in-flight items.
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.items) + len(q.inFlight)
+}
+
+// Items returns up to n queued and in-flight items in queue order.
+func (q *Queue) Items(n int) []Item {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	items := make([]Item, 0, len(q.items)+len(q.inFlight))
+	for _, item := range q.inFlight {
+		items = append(items, *item)
+	}
+	for _, item := range q.items {
+		items = append(items, *item)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Seq < items[j].Seq
+	})
+	if len(items) > n {
+		items = items[:n]
+	}
+	return items
+}
+
+func (q *Queue) notify() {
+	select {
+	case q.notifyCh <- struct{}{}:
+	default:
+	}
+}
+
+func itemKey(seq uint64) string {
+	return fmt.Sprintf("%s%020d", keyPrefix, seq)
+}
@@ -0,0 +1,167 @@
+// Copyright 2023 Victor Antonovich <v.antonovich@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Max number of years to search for the next time matched by cron expression
+const cronMaxYears = 5
+
+var months = map[string]int{
+	"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+	"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+}
+
+// cron is a standard five fields cron expression "minute hour day-of-month
+// month day-of-week". Fields are lists of values, ranges and steps, i.e.
+// "*/15", "1-5", "0,30". Months and days of week could be set by names.
+// If both days of month and days of week are restricted, time matching
+// either of them is matched, as in cron.
+type cron struct {
+	minutes [60]bool
+	hours   [24]bool
+	doms    [32]bool
+	months  [13]bool
+	dows    [7]bool
+	domAny  bool
+	dowAny  bool
+}
+
+func parseCron(s string) (*cron, error) {
+	fields := strings.Fields(s)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression must have 5 fields: %q", s)
+	}
+	c := new(cron)
+	dowNames := make(map[string]int)
+	for name, d := range weekdays {
+		dowNames[name] = int(d)
+	}
+	var dows [8]bool // Sunday is either 0 or 7
+	var err error
+	if _, err = parseCronField(fields[0], c.minutes[:], 0, nil); err != nil {
+		return nil, err
+	}
+	if _, err = parseCronField(fields[1], c.hours[:], 0, nil); err != nil {
+		return nil, err
+	}
+	if c.domAny, err = parseCronField(fields[2], c.doms[:], 1, nil); err != nil {
+		return nil, err
+	}
+	if _, err = parseCronField(fields[3], c.months[:], 1, months); err != nil {
+		return nil, err
+	}
+	if c.dowAny, err = parseCronField(fields[4], dows[:], 0, dowNames); err != nil {
+		return nil, err
+	}
+	copy(c.dows[:], dows[:7])
+	c.dows[time.Sunday] = c.dows[time.Sunday] || dows[7]
+	return c, nil
+}
+
+// parseCronField sets matched values of cron field, values are indexes
+// of given slice starting from min. It returns true if field is "*".
+func parseCronField(s string, values []bool, min int, names map[string]int) (bool, error) {
+	max := len(values) - 1
+	for _, part := range strings.Split(s, ",") {
+		rs, stepStr, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepStr); err != nil || step < 1 {
+				return false, fmt.Errorf("invalid cron step: %q", part)
+			}
+		}
+		from, to := min, max
+		if rs != "*" {
+			fs, ts, isRange := strings.Cut(rs, "-")
+			var err error
+			if from, err = parseCronValue(fs, min, max, names); err != nil {
+				return false, err
+			}
+			to = from
+			if isRange {
+				if to, err = parseCronValue(ts, min, max, names); err != nil {
+					return false, err
+				}
+			} else if hasStep {
+				// Value with step lasts until max value
+				to = max
+			}
+			if from > to {
+				return false, fmt.Errorf("invalid cron range: %q", part)
+			}
+		}
+		for v := from; v <= to; v += step {
+			values[v] = true
+		}
+	}
+	return s == "*", nil
+}
+
+func parseCronValue(s string, min, max int, names map[string]int) (int, error) {
+	if v, ok := names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < min || v > max {
+		return 0, fmt.Errorf("invalid cron value: %q", s)
+	}
+	return v, nil
+}
+
+// matches checks cron expression matches time minute
+func (c *cron) matches(t time.Time) bool {
+	return c.months[t.Month()] && c.isDayMatched(t) && c.hours[t.Hour()] && c.minutes[t.Minute()]
+}
+
+func (c *cron) isDayMatched(t time.Time) bool {
+	dom, dow := c.doms[t.Day()], c.dows[t.Weekday()]
+	if c.domAny || c.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// next returns the nearest time of minute matched by cron expression
+// since given time, or zero time if there is no such time
+func (c *cron) next(t time.Time) time.Time {
+	if t.Truncate(time.Minute) != t {
+		t = t.Truncate(time.Minute).Add(time.Minute)
+	}
+	loc := t.Location()
+	limit := t.AddDate(cronMaxYears, 0, 0)
+	for t.Before(limit) {
+		y, m, d := t.Date()
+		switch {
+		case !c.months[m]:
+			t = time.Date(y, m+1, 1, 0, 0, 0, 0, loc)
+		case !c.isDayMatched(t):
+			t = time.Date(y, m, d+1, 0, 0, 0, 0, loc)
+		case !c.hours[t.Hour()]:
+			t = time.Date(y, m, d, t.Hour()+1, 0, 0, 0, loc)
+		case !c.minutes[t.Minute()]:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
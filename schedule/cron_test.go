@@ -0,0 +1,133 @@
+// Copyright 2023 Victor Antonovich <v.antonovich@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCronErrors(t *testing.T) {
+	for _, s := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * 32 * *",
+		"* * * 0 *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"5-1 * * * *",
+		"1- * * * *",
+		"a * * * *",
+		"* * * foo *",
+		"* * * * sat-sun",
+	} {
+		if _, err := parseCron(s); err == nil {
+			t.Errorf("parseCron(%q): expected error", s)
+		}
+	}
+}
+
+func TestCronMatches(t *testing.T) {
+	for _, tc := range []struct {
+		cron    string
+		time    time.Time
+		matches bool
+	}{
+		{"* * * * *", date(1, 0, 0), true},
+		{"* 8-17 * * Mon-Fri", date(1, 8, 0), true},
+		{"* 8-17 * * Mon-Fri", date(1, 17, 59), true},
+		{"* 8-17 * * Mon-Fri", date(1, 18, 0), false},
+		{"* 8-17 * * Mon-Fri", date(6, 12, 0), false},
+		// Sunday is either 0 or 7
+		{"* * * * 0", date(7, 12, 0), true},
+		{"* * * * 7", date(7, 12, 0), true},
+		{"* * * * 7", date(6, 12, 0), false},
+		{"* * * * 6-7", date(7, 12, 0), true},
+		{"* * * * 6-7", date(5, 12, 0), false},
+		{"* * * * sat,sun", date(7, 12, 0), true},
+		{"* * * * SUN", date(7, 12, 0), true},
+		{"0,30 * * * *", date(1, 12, 30), true},
+		{"0,30 * * * *", date(1, 12, 31), false},
+		{"*/15 * * * *", date(1, 12, 45), true},
+		{"*/15 * * * *", date(1, 12, 50), false},
+		// Value with step lasts until max value
+		{"10/20 * * * *", date(1, 12, 50), true},
+		{"10/20 * * * *", date(1, 12, 0), false},
+		{"0-30/10 * * * *", date(1, 12, 30), true},
+		{"0-30/10 * * * *", date(1, 12, 40), false},
+		{"* * * jan *", date(1, 12, 0), true},
+		{"* * * feb-dec *", date(1, 12, 0), false},
+		{"* * * 1,3 *", date(1, 12, 0), true},
+		// Restricted days of month and days of week are matched by either
+		{"* * 13 * Fri", date(5, 12, 0), true},
+		{"* * 13 * Fri", date(13, 12, 0), true},
+		{"* * 13 * Fri", date(14, 12, 0), false},
+		// Unrestricted days of week don't extend restricted days of month
+		{"* * 13 * *", date(13, 12, 0), true},
+		{"* * 13 * *", date(5, 12, 0), false},
+		{"* * * * Fri", date(5, 12, 0), true},
+		{"* * * * Fri", date(13, 12, 0), false},
+		{"* * */2 * *", date(13, 12, 0), true},
+		{"* * */2 * *", date(14, 12, 0), false},
+	} {
+		c, err := parseCron(tc.cron)
+		if err != nil {
+			t.Errorf("parseCron(%q): %v", tc.cron, err)
+			continue
+		}
+		if matches := c.matches(tc.time); matches != tc.matches {
+			t.Errorf("%q matches(%v) = %v, expected %v", tc.cron, tc.time, matches, tc.matches)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	for _, tc := range []struct {
+		cron string
+		time time.Time
+		next time.Time
+	}{
+		{"* * * * *", date(1, 12, 0), date(1, 12, 0)},
+		{"* * * * *", time.Date(2024, time.January, 1, 11, 59, 30, 0, time.UTC), date(1, 12, 0)},
+		{"* 8-17 * * Mon-Fri", date(5, 18, 0), date(8, 8, 0)},
+		{"0 12 * * *", date(1, 12, 1), date(2, 12, 0)},
+		{"30 */6 * * *", date(1, 7, 0), date(1, 12, 30)},
+		{"0 0 1 * *", date(1, 0, 1), time.Date(2024, time.February, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 31 * *", date(31, 0, 1), time.Date(2024, time.March, 31, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 feb *", date(1, 0, 0), time.Date(2024, time.February, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 feb *", time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC),
+			time.Date(2028, time.February, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * dec *", date(1, 0, 0), time.Date(2024, time.December, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 0", date(1, 0, 0), date(7, 0, 0)},
+		{"0 0 13 * Fri", date(6, 0, 0), date(12, 0, 0)},
+		// There is no February 30
+		{"0 0 30 feb *", date(1, 0, 0), time.Time{}},
+	} {
+		c, err := parseCron(tc.cron)
+		if err != nil {
+			t.Errorf("parseCron(%q): %v", tc.cron, err)
+			continue
+		}
+		if next := c.next(tc.time); !next.Equal(tc.next) {
+			t.Errorf("%q next(%v) = %v, expected %v", tc.cron, tc.time, next, tc.next)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+// Copyright 2023 Victor Antonovich <v.antonovich@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schedule
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// Window is a set of time of day ranges, optionally limited to days of week.
+// Window is specified as comma separated list of ranges in form
+// "[Day[-Day]] HH:MM-HH:MM", i.e. "Mon-Fri 08:00-18:00, Sat 10:00-14:00".
+// Range ending earlier than it starts lasts until the next day.
+// Alternatively, window is specified as semicolon separated list of cron
+// expressions, and window is open within minutes matched by any of them,
+// i.e. "* 8-17 * * Mon-Fri; * 10-13 * * Sat".
+type Window struct {
+	spec   string
+	ranges []timeRange
+	crons  []*cron
+	loc    *time.Location
+}
+
+type timeRange struct {
+	days  [7]bool
+	start int // minutes since midnight
+	end   int
+}
+
+func Parse(spec string, loc *time.Location) (*Window, error) {
+	w := &Window{
+		spec: spec,
+		loc:  loc,
+	}
+	if !strings.Contains(spec, ":") {
+		// Cron expressions have no times of day
+		for _, cs := range strings.Split(spec, ";") {
+			c, err := parseCron(strings.TrimSpace(cs))
+			if err != nil {
+				return nil, fmt.Errorf("invalid time window %q: %v", spec, err)
+			}
+			w.crons = append(w.crons, c)
+		}
+		if w.NextOpen(time.Now()).IsZero() {
+			return nil, fmt.Errorf("invalid time window %q: window never opens", spec)
+		}
+		return w, nil
+	}
+	for _, rs := range strings.Split(spec, ",") {
+		r, err := parseRange(strings.TrimSpace(rs))
+		if err != nil {
+			return nil, fmt.Errorf("invalid time window %q: %v", spec, err)
+		}
+		w.ranges = append(w.ranges, r)
+	}
+	return w, nil
+}
+
+func (w *Window) String() string {
+	return fmt.Sprintf("%s (%s)", w.spec, w.loc)
+}
+
+// IsOpen checks given time is within the window
+func (w *Window) IsOpen(t time.Time) bool {
+	t = t.In(w.loc)
+	m := t.Hour()*60 + t.Minute()
+	day := t.Weekday()
+	prevDay := (day + 6) % 7
+	for _, c := range w.crons {
+		if c.matches(t) {
+			return true
+		}
+	}
+	for _, r := range w.ranges {
+		if r.start < r.end {
+			if r.days[day] && m >= r.start && m < r.end {
+				return true
+			}
+			continue
+		}
+		// Range lasting until the next day
+		if (r.days[day] && m >= r.start) || (r.days[prevDay] && m < r.end) {
+			return true
+		}
+	}
+	return false
+}
+
+// NextOpen returns the nearest time the window is open since given time
+func (w *Window) NextOpen(t time.Time) time.Time {
+	if w.IsOpen(t) {
+		return t
+	}
+	t = t.In(w.loc)
+	var next time.Time
+	for _, c := range w.crons {
+		if n := c.next(t); !n.IsZero() && (next.IsZero() || n.Before(next)) {
+			next = n
+		}
+	}
+	if len(w.crons) > 0 {
+		return next
+	}
+	for d := 0; d <= 7; d++ {
+		date := t.AddDate(0, 0, d)
+		for _, r := range w.ranges {
+			if !r.days[date.Weekday()] {
+				continue
+			}
+			start := time.Date(date.Year(), date.Month(), date.Day(), r.start/60, r.start%60, 0, 0, w.loc)
+			if start.After(t) && (next.IsZero() || start.Before(next)) {
+				next = start
+			}
+		}
+		if !next.IsZero() {
+			return next
+		}
+	}
+	return next
+}
+
+func parseRange(s string) (timeRange, error) {
+	var r timeRange
+
+	fields := strings.Fields(s)
+	switch len(fields) {
+	case 1:
+		for i := range r.days {
+			r.days[i] = true
+		}
+	case 2:
+		if err := parseDays(fields[0], &r.days); err != nil {
+			return r, err
+		}
+	default:
+		return r, fmt.Errorf("malformed range: %q", s)
+	}
+
+	start, end, found := strings.Cut(fields[len(fields)-1], "-")
+	if !found {
+		return r, fmt.Errorf("malformed time range: %q", fields[len(fields)-1])
+	}
+	var err error
+	if r.start, err = parseTime(start); err != nil {
+		return r, err
+	}
+	if r.end, err = parseTime(end); err != nil {
+		return r, err
+	}
+	if r.start == r.end {
+		return r, fmt.Errorf("empty time range: %q", fields[len(fields)-1])
+	}
+
+	return r, nil
+}
+
+func parseDays(s string, days *[7]bool) error {
+	from, to, found := strings.Cut(strings.ToLower(s), "-")
+	fromDay, ok := weekdays[from]
+	if !ok {
+		return fmt.Errorf("unknown day of week: %q", from)
+	}
+	toDay := fromDay
+	if found {
+		if toDay, ok = weekdays[to]; !ok {
+			return fmt.Errorf("unknown day of week: %q", to)
+		}
+	}
+	for d := fromDay; ; d = (d + 1) % 7 {
+		days[d] = true
+		if d == toDay {
+			return nil
+		}
+	}
+}
+
+// parseTime parses time of day returning minutes since midnight
+func parseTime(s string) (int, error) {
+	if s == "24:00" {
+		return 24 * 60, nil
+	}
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day: %q", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
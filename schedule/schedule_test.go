@@ -0,0 +1,124 @@
+// Copyright 2023 Victor Antonovich <v.antonovich@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+// date returns time of January 2024 in UTC, January 1 is Monday
+func date(day, hour, min int) time.Time {
+	return time.Date(2024, time.January, day, hour, min, 0, 0, time.UTC)
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"08:00",
+		"08:00-08:00",
+		"25:00-26:00",
+		"Mon-Fun 08:00-18:00",
+		"Mon 08:00-18:00 extra",
+		"* * * *",
+		"* * 30 feb *",
+	} {
+		if _, err := Parse(spec, time.UTC); err == nil {
+			t.Errorf("Parse(%q): expected error", spec)
+		}
+	}
+}
+
+func TestIsOpen(t *testing.T) {
+	for _, tc := range []struct {
+		spec string
+		time time.Time
+		open bool
+	}{
+		{"08:00-18:00", date(1, 8, 0), true},
+		{"08:00-18:00", date(1, 17, 59), true},
+		{"08:00-18:00", date(1, 18, 0), false},
+		{"08:00-18:00", date(1, 7, 59), false},
+		{"00:00-24:00", date(1, 23, 59), true},
+		{"Mon-Fri 08:00-18:00", date(5, 12, 0), true},
+		{"Mon-Fri 08:00-18:00", date(6, 12, 0), false},
+		{"Mon-Fri 08:00-18:00, Sat 10:00-14:00", date(6, 12, 0), true},
+		{"Mon-Fri 08:00-18:00, Sat 10:00-14:00", date(7, 12, 0), false},
+		// Weekdays range wrapping over the week end
+		{"Fri-Mon 08:00-18:00", date(7, 12, 0), true},
+		{"Fri-Mon 08:00-18:00", date(2, 12, 0), false},
+		// Overnight range lasts until the next day
+		{"22:00-06:00", date(1, 23, 0), true},
+		{"22:00-06:00", date(2, 5, 59), true},
+		{"22:00-06:00", date(2, 6, 0), false},
+		{"22:00-06:00", date(2, 12, 0), false},
+		{"Fri 22:00-06:00", date(6, 5, 0), true},
+		{"Fri 22:00-06:00", date(5, 5, 0), false},
+		// Window is open within minutes matched by any of cron expressions
+		{"* 8-17 * * Mon-Fri; * 10-13 * * Sat", date(6, 12, 0), true},
+		{"* 8-17 * * Mon-Fri; * 10-13 * * Sat", date(6, 14, 0), false},
+	} {
+		w, err := Parse(tc.spec, time.UTC)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tc.spec, err)
+			continue
+		}
+		if open := w.IsOpen(tc.time); open != tc.open {
+			t.Errorf("%q IsOpen(%v) = %v, expected %v", tc.spec, tc.time, open, tc.open)
+		}
+	}
+}
+
+func TestIsOpenLocation(t *testing.T) {
+	loc := time.FixedZone("UTC+3", 3*60*60)
+	w, err := Parse("08:00-18:00", loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !w.IsOpen(date(1, 5, 0)) {
+		t.Errorf("window is expected to be open at 08:00 in %v", loc)
+	}
+	if w.IsOpen(date(1, 15, 0)) {
+		t.Errorf("window is expected to be closed at 18:00 in %v", loc)
+	}
+}
+
+func TestNextOpen(t *testing.T) {
+	for _, tc := range []struct {
+		spec string
+		time time.Time
+		next time.Time
+	}{
+		{"08:00-18:00", date(1, 12, 0), date(1, 12, 0)},
+		{"08:00-18:00", date(1, 6, 0), date(1, 8, 0)},
+		{"08:00-18:00", date(1, 18, 0), date(2, 8, 0)},
+		{"Mon-Fri 08:00-18:00", date(5, 18, 0), date(8, 8, 0)},
+		{"Mon-Fri 08:00-18:00", date(6, 12, 0), date(8, 8, 0)},
+		{"Mon-Fri 08:00-18:00, Sat 10:00-14:00", date(5, 20, 0), date(6, 10, 0)},
+		{"Mon 08:00-18:00", date(1, 18, 0), date(8, 8, 0)},
+		{"Sun 22:00-06:00", date(8, 5, 0), date(8, 5, 0)},
+		{"Sun 22:00-06:00", date(8, 6, 0), date(14, 22, 0)},
+		{"* 8-17 * * Mon-Fri; * 10-13 * * Sat", date(5, 18, 0), date(6, 10, 0)},
+	} {
+		w, err := Parse(tc.spec, time.UTC)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tc.spec, err)
+			continue
+		}
+		if next := w.NextOpen(tc.time); !next.Equal(tc.next) {
+			t.Errorf("%q NextOpen(%v) = %v, expected %v", tc.spec, tc.time, next, tc.next)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+// Copyright 2023 Victor Antonovich <v.antonovich@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/golang/glog"
+)
+
+// Log records count (in addition to live records count) triggering compaction
+const compactThreshold = 1000
+
+// Store is a persistent key-value store kept in memory and backed
+// by append-only log file compacted from time to time.
+type Store struct {
+	mu sync.Mutex
+
+	path     string
+	f        *os.File
+	data     map[string]string
+	records  int
+	readOnly bool
+}
+
+type record struct {
+	Key     string `json:"k"`
+	Value   string `json:"v,omitempty"`
+	Deleted bool   `json:"d,omitempty"`
+}
+
+func Open(path string) (*Store, error) {
+	s := &Store{
+		path: path,
+		data: make(map[string]string),
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+
+	if err := s.load(); err != nil {
+		return nil, fmt.Errorf("can't load store %s: %w", path, err)
+	}
+
+	if err := s.compact(); err != nil {
+		return nil, fmt.Errorf("can't compact store %s: %w", path, err)
+	}
+
+	glog.V(2).Infof("opened store %s (%d record(s))", path, len(s.data))
+
+	return s, nil
+}
+
+// OpenReadOnly opens store without changing its log file,
+// so store changes are kept in memory only.
+func OpenReadOnly(path string) (*Store, error) {
+	s := &Store{
+		path:     path,
+		data:     make(map[string]string),
+		readOnly: true,
+	}
+
+	if err := s.load(); err != nil {
+		return nil, fmt.Errorf("can't load store %s: %w", path, err)
+	}
+
+	glog.V(2).Infof("opened store %s read-only (%d record(s))", path, len(s.data))
+
+	return s, nil
+}
+
+func (s *Store) Get(key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.data[key]
+	return v, ok
+}
+
+func (s *Store) Put(key, value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data[key] = value
+	return s.append(record{Key: key, Value: value})
+}
+
+func (s *Store) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.data[key]; !ok {
+		return nil
+	}
+	delete(s.data, key)
+	return s.append(record{Key: key, Deleted: true})
+}
+
+// Keys returns sorted keys having given prefix.
+func (s *Store) Keys(prefix string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]string, 0)
+	for k := range s.data {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		return nil
+	}
+	err := s.f.Close()
+	s.f = nil
+	return err
+}
+
+func (s *Store) load() error {
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var r record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			// Last record could be partially written on crash
+			glog.Warningf("skipping malformed record in store %s: %v", s.path, err)
+			continue
+		}
+		if r.Deleted {
+			delete(s.data, r.Key)
+		} else {
+			s.data[r.Key] = r.Value
+		}
+	}
+	return scanner.Err()
+}
+
+func (s *Store) append(r record) error {
+	if s.readOnly {
+		return nil
+	}
+	if s.f == nil {
+		return fmt.Errorf("store %s is closed", s.path)
+	}
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	if _, err := s.f.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	s.records++
+	if s.records > 2*len(s.data)+compactThreshold {
+		return s.compact()
+	}
+	return nil
+}
+
+// compact rewrites log file to contain live records only
+func (s *Store) compact() error {
+	tmpPath := s.path + ".tmp"
+	tf, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(tf)
+	for k, v := range s.data {
+		b, err := json.Marshal(record{Key: k, Value: v})
+		if err != nil {
+			tf.Close()
+			return err
+		}
+		if _, err := w.Write(append(b, '\n')); err != nil {
+			tf.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		tf.Close()
+		return err
+	}
+	if err := tf.Sync(); err != nil {
+		tf.Close()
+		return err
+	}
+	if err := tf.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		return err
+	}
+
+	if s.f != nil {
+		s.f.Close()
+	}
+	s.f, err = os.OpenFile(s.path, os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	s.records = len(s.data)
+	return nil
+}
@@ -0,0 +1,218 @@
+// Copyright 2023 Victor Antonovich <v.antonovich@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// contents returns store contents having given key prefix
+func contents(s *Store, prefix string) map[string]string {
+	data := make(map[string]string)
+	for _, k := range s.Keys(prefix) {
+		data[k], _ = s.Get(k)
+	}
+	return data
+}
+
+// logLines returns number of store log file records
+func logLines(t *testing.T, path string) int {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Count(string(b), "\n")
+}
+
+func TestLoad(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		log  string
+		data map[string]string
+	}{
+		{"missing", "", map[string]string{}},
+		{"put", `{"k":"a","v":"1"}` + "\n" + `{"k":"b","v":"2"}` + "\n",
+			map[string]string{"a": "1", "b": "2"}},
+		{"overwrite", `{"k":"a","v":"1"}` + "\n" + `{"k":"a","v":"2"}` + "\n",
+			map[string]string{"a": "2"}},
+		{"delete", `{"k":"a","v":"1"}` + "\n" + `{"k":"b","v":"2"}` + "\n" + `{"k":"a","d":true}` + "\n",
+			map[string]string{"b": "2"}},
+		{"put after delete", `{"k":"a","v":"1"}` + "\n" + `{"k":"a","d":true}` + "\n" + `{"k":"a","v":"3"}` + "\n",
+			map[string]string{"a": "3"}},
+		{"empty value", `{"k":"a"}` + "\n", map[string]string{"a": ""}},
+		// Last record could be partially written on crash
+		{"partial record", `{"k":"a","v":"1"}` + "\n" + `{"k":"b","v`,
+			map[string]string{"a": "1"}},
+		{"malformed record", `{"k":"a","v":"1"}` + "\n" + "garbage\n" + `{"k":"b","v":"2"}` + "\n",
+			map[string]string{"a": "1", "b": "2"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "state", "store.log")
+			if tc.log != "" {
+				if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, []byte(tc.log), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			s, err := Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+			if data := contents(s, ""); !reflect.DeepEqual(data, tc.data) {
+				t.Errorf("loaded %v, expected %v", data, tc.data)
+			}
+			// Log is compacted on open
+			if n := logLines(t, path); n != len(tc.data) {
+				t.Errorf("compacted log has %d record(s), expected %d", n, len(tc.data))
+			}
+		})
+	}
+}
+
+func TestReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.log")
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, op := range []struct {
+		key    string
+		value  string
+		delete bool
+	}{
+		{"sent/1/a", "1", false},
+		{"sent/1/b", "2", false},
+		{"hash/1/c", "3", false},
+		{"sent/1/a", "4", false},
+		{"sent/1/b", "", true},
+		{"sent/1/missing", "", true},
+	} {
+		if op.delete {
+			err = s.Delete(op.key)
+		} else {
+			err = s.Put(op.key, op.value)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := map[string]string{"sent/1/a": "4"}
+	if data := contents(s, "sent/"); !reflect.DeepEqual(data, expected) {
+		t.Errorf("store contains %v, expected %v", data, expected)
+	}
+	// Deletion of missing key is not logged
+	if n := logLines(t, path); n != 5 {
+		t.Errorf("log has %d record(s), expected 5", n)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put("sent/1/d", "5"); err == nil {
+		t.Errorf("closed store changed")
+	}
+
+	s, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	expected = map[string]string{"sent/1/a": "4", "hash/1/c": "3"}
+	if data := contents(s, ""); !reflect.DeepEqual(data, expected) {
+		t.Errorf("reopened store contains %v, expected %v", data, expected)
+	}
+}
+
+func TestCompact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.log")
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Overwrite the same keys until log is compacted
+	for i := 0; i < 3*compactThreshold; i++ {
+		if err := s.Put(fmt.Sprintf("k%d", i%10), fmt.Sprint(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := logLines(t, path); n > 2*10+compactThreshold {
+		t.Errorf("log is not compacted: %d record(s)", n)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	for i := 0; i < 10; i++ {
+		k := fmt.Sprintf("k%d", i)
+		expected := fmt.Sprint(3*compactThreshold - 10 + i)
+		if v, ok := s.Get(k); !ok || v != expected {
+			t.Errorf("%s = %q, expected %q", k, v, expected)
+		}
+	}
+}
+
+func TestOpenReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "store.log")
+	log := `{"k":"a","v":"1"}` + "\n" + `{"k":"a","v":"2"}` + "\n" + `{"k":"b","v":"3"}` + "\n"
+	if err := os.WriteFile(path, []byte(log), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	s, err := OpenReadOnly(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put("c", "4"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete("b"); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{"a": "2", "c": "4"}
+	if data := contents(s, ""); !reflect.DeepEqual(data, expected) {
+		t.Errorf("store contains %v, expected %v", data, expected)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// Log file is neither compacted nor changed
+	if b, err := os.ReadFile(path); err != nil || string(b) != log {
+		t.Errorf("read-only store log is changed: %q (%v)", b, err)
+	}
+
+	// Missing store is opened empty and is not created
+	missing := filepath.Join(dir, "missing", "store.log")
+	if s, err = OpenReadOnly(missing); err != nil {
+		t.Fatal(err)
+	}
+	if data := contents(s, ""); len(data) != 0 {
+		t.Errorf("missing store contains %v", data)
+	}
+	if _, err := os.Stat(filepath.Dir(missing)); !os.IsNotExist(err) {
+		t.Errorf("read-only store directory is created")
+	}
+}
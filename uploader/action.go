@@ -0,0 +1,204 @@
+// Copyright 2023 Victor Antonovich <v.antonovich@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package uploader
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/golang/glog"
+
+	"github.com/3cky/telegram-uploader-bot/config"
+)
+
+const (
+	ACTION_DELETE = "delete"
+	ACTION_MOVE   = "move"
+	ACTION_RENAME = "rename"
+)
+
+// action is done with uploaded file after upload is finished
+type action struct {
+	kind       string
+	dir        string
+	dateSubdir string
+	suffix     string
+}
+
+// newAction creates file action for given action config (could be nil)
+func newAction(a *config.Action) (*action, error) {
+	if a == nil || a.Action == "" {
+		return nil, nil
+	}
+	switch a.Action {
+	case ACTION_DELETE:
+	case ACTION_MOVE:
+		if a.Directory == "" {
+			return nil, fmt.Errorf("directory to move files to is not set")
+		}
+	case ACTION_RENAME:
+		if a.Suffix == "" || strings.ContainsRune(a.Suffix, os.PathSeparator) {
+			return nil, fmt.Errorf("invalid file rename suffix: %q", a.Suffix)
+		}
+	default:
+		return nil, fmt.Errorf("unknown file action: %s", a.Action)
+	}
+	return &action{
+		kind:       a.Action,
+		dir:        a.Directory,
+		dateSubdir: a.DateSubdir,
+		suffix:     a.Suffix,
+	}, nil
+}
+
+func (a *action) String() string {
+	switch a.kind {
+	case ACTION_MOVE:
+		return fmt.Sprintf("%s to %s", a.kind, a.dir)
+	case ACTION_RENAME:
+		return fmt.Sprintf("%s with suffix %s", a.kind, a.suffix)
+	}
+	return a.kind
+}
+
+func (a *action) run(path string) error {
+	switch a.kind {
+	case ACTION_DELETE:
+		return os.Remove(path)
+	case ACTION_MOVE:
+		dir := a.dir
+		if a.dateSubdir != "" {
+			dir = filepath.Join(dir, time.Now().Format(a.dateSubdir))
+		}
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+		return moveFile(path, uniquePath(filepath.Join(dir, filepath.Base(path))))
+	case ACTION_RENAME:
+		return os.Rename(path, uniquePath(path+a.suffix))
+	}
+	return nil
+}
+
+// runAction does file action (if set) and logs its result
+func runAction(a *action, path string) {
+	if a == nil {
+		return
+	}
+	if err := a.run(path); err != nil {
+		glog.Errorf("can't %s file %s: %v", a, path, err)
+		return
+	}
+	glog.V(3).Infof("file %s: %s", a, path)
+}
+
+// moveFile renames file, copying it if the destination is on another filesystem
+func moveFile(src, dst string) error {
+	err := os.Rename(src, dst)
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(dst)
+		return err
+	}
+
+	return os.Remove(src)
+}
+
+// uniquePath adds numeric suffix to file name if file with given path exists
+func uniquePath(path string) string {
+	if _, err := os.Lstat(path); os.IsNotExist(err) {
+		return path
+	}
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+	for i := 1; ; i++ {
+		p := fmt.Sprintf("%s-%d%s", base, i, ext)
+		if _, err := os.Lstat(p); os.IsNotExist(err) {
+			return p
+		}
+	}
+}
+
+// actionExcludePatterns returns patterns to exclude files
+// created by action from watching, to avoid uploading them again
+func actionExcludePatterns(a *action) []string {
+	if a == nil || a.kind != ACTION_RENAME {
+		return nil
+	}
+	escaper := strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`)
+	return []string{"*" + escaper.Replace(a.suffix)}
+}
+
+// checkActionDir checks files moved by action won't be watched again
+func checkActionDir(a *action, u config.Upload) error {
+	if a == nil || a.kind != ACTION_MOVE {
+		return nil
+	}
+	watchDir, err := resolvePath(u.Directory)
+	if err != nil {
+		return err
+	}
+	moveDir, err := resolvePath(a.dir)
+	if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(watchDir, moveDir)
+	if err != nil || !filepath.IsLocal(rel) {
+		return nil
+	}
+	if u.Recursive {
+		return fmt.Errorf("directory to move files to (%s) must be outside of watched directory", a.dir)
+	}
+	if rel == "." && a.dateSubdir == "" {
+		return fmt.Errorf("directory to move files to (%s) must not be watched directory", a.dir)
+	}
+	return nil
+}
+
+// resolvePath returns absolute path with symlinks resolved, if path exists
+func resolvePath(path string) (string, error) {
+	p, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	if rp, err := filepath.EvalSymlinks(p); err == nil {
+		return rp, nil
+	}
+	return p, nil
+}
@@ -0,0 +1,57 @@
+// Copyright 2023 Victor Antonovich <v.antonovich@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package uploader
+
+import (
+	"io/fs"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// backfill enqueues existing task files not uploaded yet
+func (u *Uploader) backfill(t *Task) {
+	var minModTime time.Time
+	if t.backfillMaxAge > 0 {
+		minModTime = time.Now().Add(-t.backfillMaxAge)
+	}
+
+	n := 0
+	err := t.watcher.Walk(func(path string, fi fs.FileInfo) {
+		if u.drainCtx.Err() != nil {
+			return
+		}
+		if fi.ModTime().Before(minModTime) {
+			return
+		}
+		sent := true
+		for _, chatId := range t.chatIds {
+			sent = sent && u.isSent(chatId, path, fi)
+		}
+		if sent {
+			return
+		}
+		if err := u.queue.Push(t.key, path); err != nil {
+			glog.Errorf("can't add %s to upload queue: %v", path, err)
+			return
+		}
+		n++
+	})
+	if err != nil {
+		glog.Warningf("task [%d] backfill can't read all files of %s: %v", t.id, t.watcher.Dir(), err)
+	}
+
+	glog.V(2).Infof("task [%d] backfill enqueued %d file(s)", t.id, n)
+}
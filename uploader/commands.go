@@ -0,0 +1,328 @@
+// Copyright 2023 Victor Antonovich <v.antonovich@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package uploader
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+
+	"github.com/3cky/telegram-uploader-bot/bot"
+)
+
+const (
+	// Max number of queued files listed by queue command
+	MAX_LISTED_QUEUE_ITEMS = 20
+
+	COMMANDS_TIME_LAYOUT = "2006-01-02 15:04:05"
+)
+
+const commandsHelp = `Commands:
+/status - show upload statistics, last upload and recent failures
+/queue - show files queued for upload
+/tasks - show watched directories
+/pause [task...] - pause uploading of files of given (or all) tasks
+/resume [task...] - resume uploading of files of given (or all) tasks
+/retry - retry uploading of recently failed files`
+
+// startCommands starts handling of commands received by current bot,
+// stopping handling of commands received by previous one, mutex must be held
+func (u *Uploader) startCommands() {
+	if u.commandsCancel != nil {
+		u.commandsCancel()
+		u.commandsCancel = nil
+	}
+	if !u.config.Telegram.Commands {
+		return
+	}
+	if u.config.Telegram.DryRun {
+		// Receiving updates would take commands sent to the running bot
+		glog.V(1).Infoln("bot commands are not handled in dry run mode")
+		return
+	}
+	if len(u.config.Telegram.Admins) == 0 && !u.config.Telegram.ChatAdmins {
+		glog.Warningf("bot commands are enabled, but no admins are set, so all commands are ignored")
+	}
+	ctx, cancel := context.WithCancel(u.drainCtx)
+	u.commandsCancel = cancel
+	// Commands are received once previous bot stops receiving them,
+	// so updates are not got by both bots at once
+	prevDoneCh := u.commandsDoneCh
+	doneCh := make(chan struct{})
+	u.commandsDoneCh = doneCh
+	tgBot := u.tgBot
+	go func() {
+		defer close(doneCh)
+		if prevDoneCh != nil {
+			<-prevDoneCh
+		}
+		u.handleCommands(ctx, tgBot)
+	}()
+}
+
+// handleCommands answers bot commands until context is canceled
+func (u *Uploader) handleCommands(ctx context.Context, tgBot *bot.Bot) {
+	if ctx.Err() != nil {
+		return
+	}
+	u.mu.RLock()
+	offset := 0
+	if u.commandsBotId == tgBot.Id() {
+		offset = u.commandsOffset
+	}
+	u.mu.RUnlock()
+
+	for cmd := range tgBot.Commands(ctx, offset) {
+		u.mu.Lock()
+		u.commandsBotId = tgBot.Id()
+		u.commandsOffset = cmd.UpdateId + 1
+		u.mu.Unlock()
+
+		if !u.isAuthorized(tgBot, cmd) {
+			glog.Warningf("ignoring command /%s from unauthorized user %d in chat %d", cmd.Name, cmd.UserId, cmd.ChatId)
+			continue
+		}
+		glog.V(2).Infof("received command /%s %s from user %d in chat %d", cmd.Name, cmd.Args, cmd.UserId, cmd.ChatId)
+		text := u.command(cmd)
+		if text == "" {
+			continue
+		}
+		if err := tgBot.SendText(u.ctx, cmd.ChatId, text, bot.SendOptions{}); err != nil {
+			glog.Errorf("can't answer command /%s in chat %d: %v", cmd.Name, cmd.ChatId, err)
+		}
+	}
+}
+
+// isAuthorized checks command is sent by bot admin, or by the chat
+// administrator if chat administrators are allowed to send commands.
+// Chat administrators are only trusted in chats files are uploaded to,
+// as anyone could create a chat and add the bot to it.
+func (u *Uploader) isAuthorized(tgBot *bot.Bot, cmd bot.Command) bool {
+	u.mu.RLock()
+	admins := u.config.Telegram.Admins
+	chatAdmins := u.config.Telegram.ChatAdmins
+	uploadChat := false
+	for _, uc := range u.config.Uploads {
+		for _, c := range append([]int64{uc.ChatId}, uc.ChatIds...) {
+			if c == cmd.ChatId {
+				uploadChat = true
+			}
+		}
+	}
+	u.mu.RUnlock()
+
+	if cmd.UserId == 0 {
+		// Command is sent on behalf of channel or anonymous group admin
+		return false
+	}
+	for _, id := range admins {
+		if id == cmd.UserId {
+			return true
+		}
+	}
+	if !chatAdmins || !uploadChat {
+		return false
+	}
+	ok, err := tgBot.IsChatAdmin(cmd.ChatId, cmd.UserId)
+	if err != nil {
+		glog.Errorf("can't check user %d is admin of chat %d: %v", cmd.UserId, cmd.ChatId, err)
+		return false
+	}
+	return ok
+}
+
+// command returns answer to the command, or empty string if command is unknown
+func (u *Uploader) command(cmd bot.Command) string {
+	switch cmd.Name {
+	case "start", "help":
+		return commandsHelp
+	case "status":
+		return u.statusCommand()
+	case "queue":
+		return u.queueCommand()
+	case "tasks":
+		return u.tasksCommand()
+	case "pause", "resume":
+		return u.pauseCommand(cmd.Name == "pause", cmd.Args)
+	case "retry":
+		return u.retryCommand()
+	}
+	return ""
+}
+
+func (u *Uploader) statusCommand() string {
+	u.mu.RLock()
+	tasks := u.tasks
+	u.mu.RUnlock()
+	paused := 0
+	for _, t := range tasks {
+		if u.isPaused(t) {
+			paused++
+		}
+	}
+
+	u.stats.mu.Lock()
+	defer u.stats.mu.Unlock()
+
+	lines := []string{
+		fmt.Sprintf("Running since %s", u.stats.started.Format(COMMANDS_TIME_LAYOUT)),
+		fmt.Sprintf("Tasks: %d (%d paused)", len(tasks), paused),
+		fmt.Sprintf("Queue: %d file(s)", u.queue.Len()),
+		fmt.Sprintf("Uploaded: %d file(s), failed: %d file(s)", u.stats.uploaded, u.stats.failed),
+	}
+	if l := u.stats.last; l != nil {
+		lines = append(lines, fmt.Sprintf("Last upload: %s to chat %d at %s",
+			l.path, l.chatId, l.time.Format(COMMANDS_TIME_LAYOUT)))
+	}
+	if len(u.stats.failures) > 0 {
+		lines = append(lines, "Recent failures:")
+		for _, f := range u.stats.failures {
+			lines = append(lines, fmt.Sprintf("%s %s to chat %d: %v",
+				f.time.Format(COMMANDS_TIME_LAYOUT), f.path, f.chatId, f.err))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (u *Uploader) queueCommand() string {
+	n := u.queue.Len()
+	if n == 0 {
+		return "Queue is empty"
+	}
+	lines := []string{fmt.Sprintf("Queue: %d file(s)", n)}
+	for _, item := range u.queue.Items(MAX_LISTED_QUEUE_ITEMS) {
+		lines = append(lines, item.Path)
+	}
+	if n > MAX_LISTED_QUEUE_ITEMS {
+		lines = append(lines, fmt.Sprintf("... and %d more file(s)", n-MAX_LISTED_QUEUE_ITEMS))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (u *Uploader) tasksCommand() string {
+	u.mu.RLock()
+	tasks := u.tasks
+	u.mu.RUnlock()
+
+	lines := make([]string, 0)
+	now := time.Now()
+	for _, t := range tasks {
+		chats := make([]string, 0)
+		for _, c := range t.chatIds {
+			chats = append(chats, strconv.FormatInt(c, 10))
+		}
+		line := fmt.Sprintf("[%d] %s -> %s", t.id, t.watcher.Dir(), strings.Join(chats, ", "))
+		if u.isPaused(t) {
+			line += " (paused)"
+		} else if until := t.heldUntil(now); !until.IsZero() {
+			line += fmt.Sprintf(" (held until %s)", until.Format(COMMANDS_TIME_LAYOUT))
+		}
+		if t.digest != nil {
+			line += fmt.Sprintf(" (next digest at %s)", t.digest.next(now).Format(COMMANDS_TIME_LAYOUT))
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (u *Uploader) pauseCommand(pause bool, args string) string {
+	u.mu.RLock()
+	tasks := u.tasks
+	u.mu.RUnlock()
+
+	// Find tasks by ids, all tasks by default
+	selected := tasks
+	if ids := strings.Fields(args); len(ids) > 0 {
+		selected = make([]*Task, 0)
+		for _, s := range ids {
+			id, err := strconv.ParseUint(s, 10, 0)
+			var task *Task
+			for _, t := range tasks {
+				if err == nil && t.id == uint(id) {
+					task = t
+				}
+			}
+			if task == nil {
+				return fmt.Sprintf("Unknown task: %s", s)
+			}
+			selected = append(selected, task)
+		}
+	}
+
+	for _, t := range selected {
+		if pause {
+			u.pause(t)
+			glog.V(1).Infof("task [%d] is paused", t.id)
+		} else {
+			u.resume(t)
+			glog.V(1).Infof("task [%d] is resumed", t.id)
+		}
+	}
+	if pause {
+		return fmt.Sprintf("Paused %d task(s)", len(selected))
+	}
+	return fmt.Sprintf("Resumed %d task(s)", len(selected))
+}
+
+// retryCommand queues recently failed files for uploading again,
+// to the chats files failed to upload to only
+func (u *Uploader) retryCommand() string {
+	type file struct {
+		task string
+		path string
+	}
+	type retry struct {
+		file
+		chats []int64
+	}
+	retries := make([]*retry, 0)
+	byFile := make(map[file]*retry)
+	missing := 0
+	for _, f := range u.stats.takeFailures() {
+		key := file{task: f.task, path: f.path}
+		r, ok := byFile[key]
+		if !ok {
+			if _, err := os.Stat(f.path); err != nil {
+				missing++
+			} else {
+				r = &retry{file: key}
+				retries = append(retries, r)
+			}
+			byFile[key] = r
+		}
+		if r != nil {
+			r.chats = append(r.chats, f.chatId)
+		}
+	}
+
+	queued := 0
+	for _, r := range retries {
+		if err := u.queue.Push(r.task, r.path, r.chats...); err != nil {
+			glog.Errorf("can't add %s to upload queue: %v", r.path, err)
+			continue
+		}
+		queued++
+	}
+	text := fmt.Sprintf("Queued %d file(s) for retry", queued)
+	if missing > 0 {
+		text += fmt.Sprintf(", %d file(s) not found", missing)
+	}
+	return text
+}
@@ -0,0 +1,282 @@
+// Copyright 2023 Victor Antonovich <v.antonovich@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package uploader
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/c2h5oh/datasize"
+	"github.com/golang/glog"
+
+	"github.com/3cky/telegram-uploader-bot/bot"
+	"github.com/3cky/telegram-uploader-bot/config"
+)
+
+const (
+	DIGEST_FORMAT_ALBUM = "album"
+	DIGEST_FORMAT_ZIP   = "zip"
+
+	// Max number of tags listed in digest summary
+	DIGEST_MAX_TAGS = 50
+)
+
+// digest is a task files digest sent periodically instead of every file
+type digest struct {
+	interval time.Duration
+	zip      bool
+	loc      *time.Location
+}
+
+// zips are digest archives created while processing files, by archived files
+type zips struct {
+	dir   string
+	files map[string][]*bot.OutgoingFile
+}
+
+// newDigest creates task digest for config, returns nil if config is not set
+func newDigest(c *config.Digest, loc *time.Location) (*digest, error) {
+	if c == nil {
+		return nil, nil
+	}
+	if c.Interval <= 0 {
+		return nil, fmt.Errorf("interval must be positive")
+	}
+	d := &digest{
+		interval: c.Interval,
+		loc:      loc,
+	}
+	switch c.Format {
+	case "", DIGEST_FORMAT_ALBUM:
+	case DIGEST_FORMAT_ZIP:
+		d.zip = true
+	default:
+		return nil, fmt.Errorf("unknown format: %s", c.Format)
+	}
+	return d, nil
+}
+
+// next returns the next digest time after given time. Digest times are
+// aligned to digest interval in digest time zone, so daily digest is sent
+// at midnight and hourly digest is sent at the start of every hour.
+func (d *digest) next(now time.Time) time.Time {
+	_, offset := now.In(d.loc).Zone()
+	shift := time.Duration(offset) * time.Second
+	return now.Add(shift).Truncate(d.interval).Add(d.interval).Add(-shift)
+}
+
+func (z *zips) close() {
+	if z.dir != "" {
+		os.RemoveAll(z.dir)
+	}
+}
+
+// zipSends returns batches of files to send uploads as digest zip archive.
+// Archive bigger than max upload size is sent as parts, if splitting is enabled.
+func (u *Uploader) zipSends(t *Task, uploads []*upload, z *zips) ([]*send, error) {
+	if len(uploads) == 0 {
+		return nil, nil
+	}
+
+	// Reuse archive of the same files already sent to another chat
+	paths := make([]string, 0, len(uploads))
+	for _, up := range uploads {
+		paths = append(paths, up.path)
+	}
+	key := strings.Join(paths, "\x00")
+	files, ok := z.files[key]
+	if !ok {
+		var err error
+		files, err = t.zip(uploads, z)
+		if err != nil {
+			return nil, err
+		}
+		z.files[key] = files
+	}
+	for _, up := range uploads {
+		up.files = files
+	}
+
+	if len(files) == 1 {
+		return []*send{{
+			uploads:  uploads,
+			files:    files,
+			document: true,
+			tags:     uploadsTags(uploads),
+		}}, nil
+	}
+
+	zu := &upload{
+		path:  files[0].Path,
+		files: files,
+	}
+	hash, err := fileHash(zu.path)
+	if err != nil {
+		return nil, err
+	}
+	zu.hash = hash
+	sends := make([]*send, 0)
+	for i, f := range files {
+		sends = append(sends, &send{
+			uploads:  uploads,
+			files:    []*bot.OutgoingFile{f},
+			document: true,
+			caption:  partCaption(zu, i),
+			tags:     uploadsTags(uploads),
+		})
+	}
+	return sends, nil
+}
+
+// zip creates zip archive of uploads in temporary directory and returns
+// archive file to send, or archive parts if archive should be split
+func (t *Task) zip(uploads []*upload, z *zips) ([]*bot.OutgoingFile, error) {
+	if z.dir == "" {
+		dir, err := os.MkdirTemp("", "telegram-uploader-bot-")
+		if err != nil {
+			return nil, err
+		}
+		z.dir = dir
+	}
+	name := fmt.Sprintf("%s-%s", filepath.Base(t.watcher.Dir()), time.Now().In(t.digest.loc).Format("2006-01-02-1504"))
+	if len(z.files) > 0 {
+		name = fmt.Sprintf("%s-%d", name, len(z.files)+1)
+	}
+	zp := filepath.Join(z.dir, name+".zip")
+	glog.V(3).Infof("task [%d] archiving %d file(s) to %s", t.id, len(uploads), zp)
+	if err := writeZip(zp, t.watcher.Dir(), uploads); err != nil {
+		return nil, fmt.Errorf("can't create digest archive: %v", err)
+	}
+
+	fi, err := os.Stat(zp)
+	if err != nil {
+		return nil, err
+	}
+	if fi.Size() <= int64(t.maxSize) {
+		return []*bot.OutgoingFile{{Path: zp}}, nil
+	}
+	if !t.split {
+		return nil, fmt.Errorf("digest archive is too big (%d byte(s))", fi.Size())
+	}
+	if (fi.Size()+int64(t.maxSize)-1)/int64(t.maxSize) > MAX_SPLIT_PARTS {
+		return nil, fmt.Errorf("digest archive is too big to split (%d byte(s))", fi.Size())
+	}
+	return splitFile(zp, fi.Size(), int64(t.maxSize)), nil
+}
+
+// writeZip writes uploads to zip archive, with file names relative to given directory
+func writeZip(zp, dir string, uploads []*upload) error {
+	f, err := os.Create(zp)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	zw := zip.NewWriter(f)
+	for _, up := range uploads {
+		name, err := filepath.Rel(dir, up.path)
+		if err != nil || !filepath.IsLocal(name) {
+			name = filepath.Base(up.path)
+		}
+		h, err := zip.FileInfoHeader(up.fi)
+		if err != nil {
+			return err
+		}
+		h.Name = filepath.ToSlash(name)
+		h.Method = zip.Deflate
+		w, err := zw.CreateHeader(h)
+		if err != nil {
+			return err
+		}
+		if err := copyFile(w, up.path); err != nil {
+			return err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+
+	return f.Close()
+}
+
+func copyFile(w io.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// sendSummary sends summary of digest files sent to the chat
+func (u *Uploader) sendSummary(t *Task, chatId int64, uploads []*upload) {
+	if len(uploads) == 0 {
+		return
+	}
+	text := digestSummary(uploads)
+	err := u.retry(t.retry, fmt.Sprintf("sending of digest summary to chat %d", chatId), func() error {
+		return u.bot().SendText(u.ctx, chatId, text, bot.SendOptions{Silent: t.isSilent()})
+	})
+	if err != nil && u.ctx.Err() == nil {
+		glog.Errorf("can't send digest summary to chat %d: %v", chatId, err)
+	}
+}
+
+// digestSummary returns digest files count, total size and tags histogram
+func digestSummary(uploads []*upload) string {
+	var size int64
+	tags := make([]string, 0)
+	counts := make(map[string]int)
+	for _, up := range uploads {
+		size += up.fi.Size()
+		tagSet := make(map[string]bool)
+		for _, tag := range up.tags {
+			if tagSet[tag] {
+				continue
+			}
+			tagSet[tag] = true
+			if counts[tag] == 0 {
+				tags = append(tags, tag)
+			}
+			counts[tag]++
+		}
+	}
+	sort.SliceStable(tags, func(i, j int) bool {
+		return counts[tags[i]] > counts[tags[j]]
+	})
+
+	lines := []string{fmt.Sprintf("Digest: %d file(s), %s", len(uploads), datasize.ByteSize(size).HumanReadable())}
+	for i, tag := range tags {
+		if i == DIGEST_MAX_TAGS {
+			lines = append(lines, fmt.Sprintf("... and %d more tag(s)", len(tags)-i))
+			break
+		}
+		lines = append(lines, fmt.Sprintf("%s: %d", bot.Hashtags([]string{tag}), counts[tag]))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// isZipDigest checks task files are sent as digest zip archives
+func (t *Task) isZipDigest() bool {
+	return t.digest != nil && t.digest.zip
+}
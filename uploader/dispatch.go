@@ -0,0 +1,284 @@
+// Copyright 2023 Victor Antonovich <v.antonovich@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package uploader
+
+import (
+	"time"
+
+	"github.com/golang/glog"
+
+	"github.com/3cky/telegram-uploader-bot/queue"
+)
+
+// job is a batch of queued files to upload by task. Job of task with
+// multiple chats is added to all the chat lanes and is run once it's
+// the first job of every lane, so files are uploaded in queue order
+// for every chat. Jobs are added to lanes at once, so jobs order
+// is the same for all lanes.
+type job struct {
+	task    *Task
+	items   []*queue.Item
+	arrived int           // number of lanes job is the first job of
+	doneCh  chan struct{} // closed once job is done or released
+}
+
+// album is a batch of task files collected to be sent together
+type album struct {
+	items []*queue.Item
+	timer *time.Timer
+}
+
+// dispatch adds queued file to its chat lanes. Every chat lane is processed
+// by its own goroutine, so files are uploaded to different chats in parallel
+// (limited by workers count) and in queue order for the same chat.
+func (u *Uploader) dispatch(item *queue.Item) {
+	t, err := u.findTask(item)
+	if err != nil {
+		glog.Errorf("skipping uploading of %s: %v", item.Path, err)
+		u.finish(item, true)
+		return
+	}
+
+	u.lanesMu.Lock()
+	defer u.lanesMu.Unlock()
+
+	u.route(t, item)
+}
+
+// route adds task file to the task album or chat lane, or holds it
+// if task files can't be uploaded now, lanes lock must be held
+func (u *Uploader) route(t *Task, item *queue.Item) {
+	if u.drainCtx.Err() != nil {
+		u.finish(item, false)
+		return
+	}
+
+	if t.paused {
+		u.hold(t, item, time.Time{})
+		return
+	}
+
+	if until := t.heldUntil(time.Now()); !until.IsZero() {
+		u.hold(t, item, until)
+		return
+	}
+
+	if t.albumWindow > 0 || t.digest != nil {
+		u.addToAlbum(t, item)
+		return
+	}
+
+	u.addJob(t, []*queue.Item{item})
+}
+
+// addJob adds job of task files to the task chat lanes, lanes lock must be held
+func (u *Uploader) addJob(t *Task, items []*queue.Item) {
+	j := &job{
+		task:   t,
+		items:  items,
+		doneCh: make(chan struct{}),
+	}
+	for _, chatId := range t.chatIds {
+		jobs, running := u.lanes[chatId]
+		u.lanes[chatId] = append(jobs, j)
+		if !running {
+			u.lanesWg.Add(1)
+			go u.runLane(chatId)
+		}
+	}
+}
+
+// addToAlbum adds file to the task album collected during album time window
+// since the album first file, or until the next task digest time, lanes
+// lock must be held
+func (u *Uploader) addToAlbum(t *Task, item *queue.Item) {
+	a, ok := u.albums[t]
+	if !ok {
+		a = &album{
+			items: make([]*queue.Item, 0),
+		}
+		window := t.albumWindow
+		if t.digest != nil {
+			next := t.digest.next(time.Now())
+			glog.V(3).Infof("task [%d] collecting files for digest at %v", t.id, next)
+			window = time.Until(next)
+		}
+		a.timer = time.AfterFunc(window, func() {
+			u.flushAlbum(t, a)
+		})
+		u.albums[t] = a
+	}
+	a.items = append(a.items, item)
+}
+
+// flushAlbum adds collected album files job to the task chat lane
+func (u *Uploader) flushAlbum(t *Task, a *album) {
+	u.lanesMu.Lock()
+	defer u.lanesMu.Unlock()
+
+	if u.albums[t] != a {
+		return
+	}
+	delete(u.albums, t)
+
+	if u.drainCtx.Err() != nil {
+		for _, item := range a.items {
+			u.finish(item, false)
+		}
+		return
+	}
+
+	glog.V(4).Infof("task [%d] album of %d file(s) collected", t.id, len(a.items))
+	u.addJob(t, a.items)
+}
+
+// dropAlbums returns files of albums being collected back to the upload queue
+func (u *Uploader) dropAlbums() {
+	u.lanesMu.Lock()
+	defer u.lanesMu.Unlock()
+
+	for t, a := range u.albums {
+		a.timer.Stop()
+		for _, item := range a.items {
+			u.finish(item, false)
+		}
+		delete(u.albums, t)
+	}
+}
+
+// dropTask returns files collected by task and its lane jobs not started
+// yet back to the upload queue
+func (u *Uploader) dropTask(t *Task) {
+	u.lanesMu.Lock()
+	defer u.lanesMu.Unlock()
+
+	// Jobs being run or waited for by some of lanes are kept
+	var items []*queue.Item
+	dropped := make(map[*job]bool)
+	for chatId, jobs := range u.lanes {
+		kept := make([]*job, 0, len(jobs))
+		for _, j := range jobs {
+			if j.task != t || j.arrived > 0 {
+				kept = append(kept, j)
+				continue
+			}
+			if !dropped[j] {
+				dropped[j] = true
+				items = append(items, j.items...)
+				close(j.doneCh)
+			}
+		}
+		u.lanes[chatId] = kept
+	}
+	if a, ok := u.albums[t]; ok {
+		a.timer.Stop()
+		items = append(items, a.items...)
+		delete(u.albums, t)
+	}
+	if h, ok := u.held[t]; ok {
+		if h.timer != nil {
+			h.timer.Stop()
+		}
+		items = append(items, h.items...)
+		delete(u.held, t)
+	}
+	for _, item := range items {
+		u.finish(item, false)
+	}
+}
+
+// runLane processes chat lane jobs until the lane is empty. When uploader
+// is stopping, the lane jobs not started yet are returned back to the queue.
+func (u *Uploader) runLane(chatId int64) {
+	defer u.lanesWg.Done()
+
+	for {
+		u.lanesMu.Lock()
+		jobs := u.lanes[chatId]
+		if len(jobs) == 0 || u.drainCtx.Err() != nil {
+			for _, j := range jobs {
+				u.releaseJob(j)
+			}
+			delete(u.lanes, chatId)
+			u.lanesMu.Unlock()
+			return
+		}
+		j := jobs[0]
+		j.arrived++
+		if j.arrived < len(j.task.chatIds) {
+			// Wait for the job to be run by the last lane it's the first job of
+			u.lanesMu.Unlock()
+			<-j.doneCh
+			u.lanesMu.Lock()
+			u.lanes[chatId] = removeJob(u.lanes[chatId], j)
+			u.lanesMu.Unlock()
+			continue
+		}
+		u.lanes[chatId] = jobs[1:]
+		workersCh := u.workersCh
+		u.lanesMu.Unlock()
+
+		// Wait for free worker
+		done := false
+		select {
+		case workersCh <- struct{}{}:
+			done = u.process(j.task, j.items)
+			<-workersCh
+		case <-u.drainCtx.Done():
+		}
+
+		for _, item := range j.items {
+			u.finish(item, done)
+		}
+		close(j.doneCh)
+	}
+}
+
+// releaseJob returns files of job not started yet back to the queue,
+// unless it's already done by another lane, lanes lock must be held
+func (u *Uploader) releaseJob(j *job) {
+	select {
+	case <-j.doneCh:
+		return
+	default:
+	}
+	for _, item := range j.items {
+		u.finish(item, false)
+	}
+	close(j.doneCh)
+}
+
+// removeJob returns lane jobs without given one
+func removeJob(jobs []*job, j *job) []*job {
+	kept := make([]*job, 0, len(jobs))
+	for _, lj := range jobs {
+		if lj != j {
+			kept = append(kept, lj)
+		}
+	}
+	return kept
+}
+
+// finish removes processed item from the upload queue
+// or returns it back to the queue if processing was interrupted
+func (u *Uploader) finish(item *queue.Item, done bool) {
+	if !done {
+		u.queue.Release(item)
+		return
+	}
+	if err := u.queue.Ack(item); err != nil {
+		glog.Errorf("can't remove %s from upload queue: %v", item.Path, err)
+	}
+}
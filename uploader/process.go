@@ -0,0 +1,442 @@
+// Copyright 2023 Victor Antonovich <v.antonovich@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package uploader
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/golang/glog"
+
+	"github.com/3cky/telegram-uploader-bot/bot"
+	"github.com/3cky/telegram-uploader-bot/imaging"
+	"github.com/3cky/telegram-uploader-bot/queue"
+	"github.com/3cky/telegram-uploader-bot/util"
+)
+
+// Max number of parts to split file to
+const MAX_SPLIT_PARTS = 999
+
+// upload is a file to upload by task
+type upload struct {
+	path   string
+	fi     fs.FileInfo
+	hash   string
+	tags   []string
+	files  []*bot.OutgoingFile // file parts, if file is split
+	tmp    string              // temporary file to upload instead of original
+	chats  []int64             // chats to upload file to, all task chats if empty
+	sent   bool                // sent (or skipped as duplicate) to some of chats
+	failed bool
+}
+
+// send is a batch of files to send in one go
+type send struct {
+	uploads  []*upload
+	files    []*bot.OutgoingFile
+	document bool
+	caption  string
+	tags     []string
+}
+
+// process uploads files by task, sending them together as albums if possible.
+// It returns false if processing is interrupted by uploader stop and files
+// should be uploaded later.
+func (u *Uploader) process(t *Task, items []*queue.Item) bool {
+	uploads := make([]*upload, 0)
+	for _, item := range items {
+		if up := u.prepare(t, item.Path); up != nil {
+			up.chats = item.Chats
+			uploads = append(uploads, up)
+		}
+	}
+	if len(uploads) == 0 {
+		return true
+	}
+	defer func() {
+		for _, up := range uploads {
+			if up.tmp != "" {
+				os.Remove(up.tmp)
+			}
+		}
+	}()
+	z := &zips{
+		files: make(map[string][]*bot.OutgoingFile),
+	}
+	defer z.close()
+
+	// Upload files to the first chat and send uploaded files to other chats
+	for _, chatId := range t.chatIds {
+		pending := make([]*upload, 0)
+		// Files with the same content as pending ones are not uploaded
+		// and are handled as the pending ones after upload
+		hashes := make(map[string]*upload)
+		dups := make(map[*upload]*upload)
+		for _, up := range uploads {
+			if !up.isFor(chatId) {
+				continue
+			}
+			// Check file is not uploaded already
+			if u.isSent(chatId, up.path, up.fi) {
+				glog.V(3).Infof("skipping uploading of already uploaded file to chat %d: %s", chatId, up.path)
+				// Post-upload action could be not done if uploader is stopped after upload
+				up.sent = true
+				continue
+			}
+			if up.hash != "" {
+				if sentPath, ok := u.findSentHash(chatId, up.hash); ok {
+					glog.V(3).Infof("skipping uploading of duplicate file %s to chat %d (already uploaded %s)",
+						up.path, chatId, sentPath)
+					if !u.isDryRun() {
+						u.markSent(chatId, up.path, up.fi)
+					}
+					// Duplicate is handled as uploaded one by post-upload actions
+					up.sent = true
+					continue
+				}
+				if orig, ok := hashes[up.hash]; ok {
+					glog.V(3).Infof("skipping uploading of duplicate file %s to chat %d (uploading %s)",
+						up.path, chatId, orig.path)
+					dups[up] = orig
+					continue
+				}
+				hashes[up.hash] = up
+			}
+			for _, f := range up.files {
+				f.Sent = false
+			}
+			pending = append(pending, up)
+		}
+
+		ss := sends(t, pending)
+		if t.isZipDigest() {
+			var err error
+			ss, err = u.zipSends(t, pending, z)
+			if err != nil {
+				for _, up := range pending {
+					up.failed = true
+					u.stats.addFailed(t, up.path, chatId, err)
+				}
+				glog.Errorf("can't upload digest of %d file(s) to chat %d: %v", len(pending), chatId, err)
+			}
+		}
+		// Parts of file failed to upload are not sent,
+		// as file can't be reassembled anyway
+		failed := make(map[*upload]bool)
+		for _, s := range ss {
+			if isFailed(s, failed) {
+				continue
+			}
+			desc := fmt.Sprintf("uploading of %s to chat %d", s.files[0], chatId)
+			if len(s.files) > 1 {
+				desc = fmt.Sprintf("uploading of %d files to chat %d", len(s.files), chatId)
+			}
+			opts := bot.SendOptions{
+				Document:  s.document,
+				Caption:   s.caption,
+				Silent:    t.isSilent(),
+				Bandwidth: t.bandwidth,
+				Tags:      s.tags,
+			}
+			err := u.retry(t.retry, desc, func() error {
+				return u.bot().SendFiles(u.ctx, chatId, s.files, opts)
+			})
+			if u.ctx.Err() != nil {
+				return false
+			}
+			if err != nil {
+				for _, up := range s.uploads {
+					if up.isSent() {
+						continue
+					}
+					up.failed = true
+					failed[up] = true
+					u.stats.addFailed(t, up.path, chatId, err)
+					glog.Errorf("can't upload file %s to chat %d: %v", up.path, chatId, err)
+				}
+			}
+		}
+
+		sent := make([]*upload, 0)
+		for _, up := range pending {
+			if !up.isSent() {
+				continue
+			}
+			up.sent = true
+			sent = append(sent, up)
+			u.stats.addUploaded(up.path, chatId)
+			if u.isDryRun() {
+				continue
+			}
+			u.markSent(chatId, up.path, up.fi)
+			if up.hash != "" {
+				u.markHashSent(chatId, up.hash, up.path)
+			}
+		}
+		for up, orig := range dups {
+			if !orig.isSent() {
+				up.failed = true
+				u.stats.addFailed(t, up.path, chatId, fmt.Errorf("duplicate file %s is not uploaded", orig.path))
+				continue
+			}
+			up.sent = true
+			if !u.isDryRun() {
+				u.markSent(chatId, up.path, up.fi)
+			}
+		}
+		if t.digest != nil {
+			u.sendSummary(t, chatId, sent)
+		}
+	}
+
+	// Do post-upload file actions
+	if u.isDryRun() {
+		return true
+	}
+	for _, up := range uploads {
+		if up.failed {
+			runAction(t.onFailure, up.path)
+		} else if up.sent {
+			runAction(t.onSuccess, up.path)
+		}
+	}
+
+	return true
+}
+
+// sends returns batches of files to send for uploads. Whole files are sent
+// in one batch with caption of all files tags, parts of every split file
+// are sent one by one with parts description.
+func sends(t *Task, uploads []*upload) []*send {
+	sends := make([]*send, 0)
+
+	batch := &send{
+		document: t.document,
+	}
+	for _, up := range uploads {
+		if len(up.files) > 1 {
+			continue
+		}
+		batch.uploads = append(batch.uploads, up)
+		batch.files = append(batch.files, up.files[0])
+	}
+	if len(batch.files) > 0 {
+		batch.tags = uploadsTags(batch.uploads)
+		if t.digest == nil {
+			// Digest tags are listed in digest summary
+			batch.caption = bot.Hashtags(batch.tags)
+		}
+		sends = append(sends, batch)
+	}
+
+	for _, up := range uploads {
+		if len(up.files) == 1 {
+			continue
+		}
+		for i, f := range up.files {
+			sends = append(sends, &send{
+				uploads:  []*upload{up},
+				files:    []*bot.OutgoingFile{f},
+				document: true,
+				caption:  partCaption(up, i),
+				tags:     up.tags,
+			})
+		}
+	}
+
+	return sends
+}
+
+// isFailed checks all files of send are failed to upload
+func isFailed(s *send, failed map[*upload]bool) bool {
+	for _, up := range s.uploads {
+		if !failed[up] {
+			return false
+		}
+	}
+	return true
+}
+
+// uploadsTags returns tags of all uploads
+func uploadsTags(uploads []*upload) []string {
+	tags := make([]string, 0)
+	tagSet := make(map[string]bool)
+	for _, up := range uploads {
+		for _, tag := range up.tags {
+			if !tagSet[tag] {
+				tags = append(tags, tag)
+				tagSet[tag] = true
+			}
+		}
+	}
+	return tags
+}
+
+// isFor checks file should be uploaded to the chat
+func (up *upload) isFor(chatId int64) bool {
+	if len(up.chats) == 0 {
+		return true
+	}
+	for _, c := range up.chats {
+		if c == chatId {
+			return true
+		}
+	}
+	return false
+}
+
+func (up *upload) isSent() bool {
+	for _, f := range up.files {
+		if !f.Sent {
+			return false
+		}
+	}
+	return true
+}
+
+// prepare checks file could be uploaded by task and gets file
+// content hash and tags. It returns nil if file should be skipped.
+func (u *Uploader) prepare(t *Task, fp string) *upload {
+	// Check file size
+	fi, err := os.Stat(fp)
+	if err != nil {
+		glog.Errorf("can't stat file to upload %s: %v", fp, err)
+		if !os.IsNotExist(err) {
+			u.skipFailed(t, fp)
+		}
+		return nil
+	}
+	if fi.Size() < int64(t.minSize) {
+		glog.V(3).Infof("skipping uploading of too small file (%d byte(s)): %s", fi.Size(), fp)
+		return nil
+	}
+	split := fi.Size() > int64(t.maxSize)
+	if split && !t.split {
+		glog.Warningf("skipping uploading of too big file (%d byte(s)): %s", fi.Size(), fp)
+		u.skipFailed(t, fp)
+		return nil
+	}
+	if split && (fi.Size()+int64(t.maxSize)-1)/int64(t.maxSize) > MAX_SPLIT_PARTS {
+		glog.Warningf("skipping uploading of file too big to split (%d byte(s)): %s", fi.Size(), fp)
+		u.skipFailed(t, fp)
+		return nil
+	}
+	// Get file content hash to check file with the same content is not uploaded already
+	// or to provide checksum of split file
+	var hash string
+	if t.dedupe || split {
+		hash, err = fileHash(fp)
+		if err != nil {
+			glog.Errorf("can't get content hash of file to upload %s: %v", fp, err)
+			u.skipFailed(t, fp)
+			return nil
+		}
+	}
+	// Get file tags
+	tags := make([]string, 0)
+	for _, tg := range t.taggers {
+		tags = append(tags, tg.Tags(fp)...)
+	}
+	up := &upload{
+		path: fp,
+		fi:   fi,
+		hash: hash,
+		tags: tags,
+	}
+	if t.isZipDigest() {
+		// Digest archive is split instead, if needed
+		up.files = []*bot.OutgoingFile{{Path: fp}}
+	} else if split {
+		up.files = splitFile(fp, fi.Size(), int64(t.maxSize))
+		glog.V(3).Infof("splitting too big file (%d byte(s)) to %d parts: %s", fi.Size(), len(up.files), fp)
+	} else {
+		up.files = []*bot.OutgoingFile{{Path: fp}}
+		if !t.document && bot.MediaKind(fp) == bot.FileKindPhoto && !util.IsFileExtensionMatched(fp, "gif") {
+			u.fitPhoto(t, up)
+		}
+	}
+	return up
+}
+
+// skipFailed does failure action with file can't be uploaded
+func (u *Uploader) skipFailed(t *Task, fp string) {
+	if !u.isDryRun() {
+		runAction(t.onFailure, fp)
+	}
+}
+
+// fitPhoto downscales photo to fit Telegram photo limits, if needed
+func (u *Uploader) fitPhoto(t *Task, up *upload) {
+	limits := imaging.TelegramLimits(t.photoMaxDimension)
+	fits, err := imaging.FitsLimits(up.path, limits)
+	if errors.Is(err, imaging.ErrAspectRatio) {
+		glog.V(3).Infof("photo %s aspect ratio exceeds limit, sending it as document", up.path)
+		up.files[0].Document = true
+		return
+	}
+	if err != nil {
+		glog.Warningf("can't check photo %s dimensions: %v", up.path, err)
+		return
+	}
+	if fits {
+		return
+	}
+	tmp, err := imaging.FitToJpeg(up.path, limits, t.jpegQuality)
+	if err != nil {
+		glog.Warningf("can't downscale photo %s: %v", up.path, err)
+		return
+	}
+	glog.V(3).Infof("photo %s is downscaled to %s", up.path, tmp)
+	up.tmp = tmp
+	up.files[0].Path = tmp
+	up.files[0].Original = up.path
+}
+
+// splitFile returns file parts of given max size
+func splitFile(fp string, size, partSize int64) []*bot.OutgoingFile {
+	name := filepath.Base(fp)
+	parts := make([]*bot.OutgoingFile, 0)
+	for offset, i := int64(0), 1; offset < size; offset, i = offset+partSize, i+1 {
+		length := partSize
+		if offset+length > size {
+			length = size - offset
+		}
+		parts = append(parts, &bot.OutgoingFile{
+			Path: fp,
+			Part: &bot.FilePart{
+				Name:   fmt.Sprintf("%s.%03d", name, i),
+				Offset: offset,
+				Length: length,
+			},
+		})
+	}
+	return parts
+}
+
+// partCaption returns split file part caption with reassembling instructions
+func partCaption(up *upload, i int) string {
+	name := filepath.Base(up.path)
+	caption := fmt.Sprintf("%s part %d of %d\n"+
+		"Reassemble: cat %s.??? > %s\n"+
+		"SHA-256: %s",
+		name, i+1, len(up.files), name, name, up.hash)
+	if ht := bot.Hashtags(up.tags); ht != "" {
+		caption = ht + "\n" + caption
+	}
+	return caption
+}
@@ -0,0 +1,173 @@
+// Copyright 2023 Victor Antonovich <v.antonovich@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package uploader
+
+import (
+	"fmt"
+
+	"github.com/golang/glog"
+
+	"github.com/3cky/telegram-uploader-bot/bot"
+	"github.com/3cky/telegram-uploader-bot/config"
+)
+
+// Reload applies changed config to running uploader. Tasks of unchanged
+// upload configs are kept running, tasks of removed or changed upload configs
+// are stopped and tasks of new upload configs are started. Files being
+// uploaded by stopped tasks are uploaded using old task settings. Telegram
+// bot is recreated only if Telegram settings are changed. If changed config
+// can't be used, uploader is kept running with current config.
+func (u *Uploader) Reload(config *config.Config) error {
+	u.mu.RLock()
+	current := u.config
+	tasks := u.tasks
+	nextId := u.nextId
+	u.mu.RUnlock()
+
+	workers, err := workersCount(config)
+	if err != nil {
+		return err
+	}
+	if _, err := shutdownTimeout(config); err != nil {
+		return err
+	}
+	limits, err := rateLimits(config.Telegram.RateLimit)
+	if err != nil {
+		return err
+	}
+
+	// Task settings depend on global retry policy and Bot API server mode,
+	// so all tasks should be recreated if these are changed
+	keepTasks := config.Retry == current.Retry && config.Telegram.Local == current.Telegram.Local
+
+	// Find tasks to keep and create tasks for changed upload configs
+	all := make([]*Task, 0)
+	kept := make([]*Task, 0)
+	keptSet := make(map[*Task]bool)
+	added := make([]*Task, 0)
+	for _, uc := range config.Uploads {
+		key := taskKey(uc)
+		var keep *Task
+		for _, t := range tasks {
+			if keepTasks && t.key == key && !keptSet[t] {
+				keep = t
+				break
+			}
+		}
+		if keep != nil {
+			all = append(all, keep)
+			kept = append(kept, keep)
+			keptSet[keep] = true
+			continue
+		}
+		t, err := newTask(nextId, uc, config, u.store)
+		if err != nil {
+			return err
+		}
+		all = append(all, t)
+		added = append(added, t)
+		nextId++
+	}
+	if len(kept)+len(added) == 0 {
+		return fmt.Errorf("no directories to watch for new files")
+	}
+
+	// Create telegram bot if its settings are changed
+	var tgBot *bot.Bot
+	if isBotChanged(config.Telegram, current.Telegram) {
+		if tgBot, err = newBot(config.Telegram, u.rateLimiter); err != nil {
+			return err
+		}
+	}
+
+	// Keep tasks in config order, skipping tasks with directories can't be watched
+	added = watchTasks(added, u.eventCh)
+	watched := make([]*Task, 0)
+	for _, t := range all {
+		if t.watcher != nil {
+			watched = append(watched, t)
+		}
+	}
+	removed := make([]*Task, 0)
+	for _, t := range tasks {
+		if !keptSet[t] {
+			removed = append(removed, t)
+		}
+	}
+
+	u.mu.Lock()
+	u.config = config
+	if tgBot != nil {
+		u.tgBot = tgBot
+	}
+	u.tasks = watched
+	u.nextId = nextId
+	started := u.started
+	u.mu.Unlock()
+
+	u.rateLimiter.SetLimits(limits)
+
+	u.lanesMu.Lock()
+	if cap(u.workersCh) != workers {
+		// Uploads in progress release their workers to the old channel
+		u.workersCh = make(chan struct{}, workers)
+	}
+	u.lanesMu.Unlock()
+
+	// Stop removed tasks before starting added ones, as directories are
+	// watched only by started watchers, so files are not reported by both
+	// removed and added tasks. Files collected by removed tasks are returned
+	// back to the queue, so the files are uploaded by tasks watching the file
+	// directories, and files not emitted yet by removed tasks watchers are
+	// emitted by added tasks watchers. Watchers of tasks removed before
+	// uploader start were never started.
+	for _, t := range removed {
+		if started {
+			t.watcher.Stop()
+			for _, a := range added {
+				a.watcher.Resume(t.watcher)
+			}
+		}
+		u.dropTask(t)
+	}
+
+	if started {
+		u.mu.Lock()
+		u.startTasks(added)
+		if tgBot != nil || config.Telegram.Commands != current.Telegram.Commands {
+			u.startCommands()
+		}
+		u.mu.Unlock()
+	}
+
+	glog.V(1).Infof("config reloaded: %d task(s) kept, %d task(s) added, %d task(s) removed",
+		len(kept), len(added), len(removed))
+	if tgBot != nil {
+		glog.V(1).Infoln("telegram bot is recreated with changed settings")
+	}
+
+	return nil
+}
+
+// isBotChanged checks Telegram settings changes require bot to be recreated.
+// Rate limits are applied to the rate limiter shared by bots, bot commands
+// settings are applied on commands receiving.
+func isBotChanged(c, current config.Telegram) bool {
+	return c.Token != current.Token ||
+		c.ApiEndpoint != current.ApiEndpoint ||
+		c.Local != current.Local ||
+		c.Bandwidth != current.Bandwidth ||
+		c.DryRun != current.DryRun
+}
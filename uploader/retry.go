@@ -0,0 +1,100 @@
+// Copyright 2023 Victor Antonovich <v.antonovich@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package uploader
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/golang/glog"
+
+	"github.com/3cky/telegram-uploader-bot/bot"
+	"github.com/3cky/telegram-uploader-bot/config"
+)
+
+const (
+	DEFAULT_RETRY_ATTEMPTS  = 5
+	DEFAULT_RETRY_DELAY     = 2 * time.Second
+	DEFAULT_RETRY_MAX_DELAY = 5 * time.Minute
+)
+
+type retryPolicy struct {
+	attempts int
+	delay    time.Duration
+	maxDelay time.Duration
+}
+
+// newRetryPolicy creates retry policy from global retry config
+// and upload retry config overriding it (could be nil)
+func newRetryPolicy(global config.Retry, upload *config.Retry) (retryPolicy, error) {
+	p := retryPolicy{
+		attempts: DEFAULT_RETRY_ATTEMPTS,
+		delay:    DEFAULT_RETRY_DELAY,
+		maxDelay: DEFAULT_RETRY_MAX_DELAY,
+	}
+	for _, r := range []*config.Retry{&global, upload} {
+		if r == nil {
+			continue
+		}
+		if r.Attempts < 0 || r.Delay < 0 || r.MaxDelay < 0 {
+			return p, fmt.Errorf("retry settings must not be negative")
+		}
+		if r.Attempts > 0 {
+			p.attempts = r.Attempts
+		}
+		if r.Delay > 0 {
+			p.delay = r.Delay
+		}
+		if r.MaxDelay > 0 {
+			p.maxDelay = r.MaxDelay
+		}
+	}
+	if p.maxDelay < p.delay {
+		return p, fmt.Errorf("retry max delay (%v) must not be less than delay (%v)", p.maxDelay, p.delay)
+	}
+	return p, nil
+}
+
+// retry calls fn until it succeeds, fails with permanent error or retry attempts are exhausted.
+// Delay between attempts grows exponentially unless delay is requested by Telegram.
+func (u *Uploader) retry(p retryPolicy, desc string, fn func() error) error {
+	delay := p.delay
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= p.attempts || u.ctx.Err() != nil {
+			return err
+		}
+		transient, retryAfter := bot.IsTransientError(err)
+		if !transient {
+			return err
+		}
+		// Add jitter to avoid retries from different uploads going in lockstep
+		wait := delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+		if retryAfter > 0 {
+			wait = retryAfter
+		}
+		glog.Warningf("%s failed (attempt %d of %d): %v, retrying in %v", desc, attempt, p.attempts, err, wait)
+		select {
+		case <-time.After(wait):
+		case <-u.ctx.Done():
+			return u.ctx.Err()
+		}
+		delay *= 2
+		if delay > p.maxDelay {
+			delay = p.maxDelay
+		}
+	}
+}
@@ -0,0 +1,161 @@
+// Copyright 2023 Victor Antonovich <v.antonovich@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package uploader
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+
+	"github.com/3cky/telegram-uploader-bot/config"
+	"github.com/3cky/telegram-uploader-bot/queue"
+	"github.com/3cky/telegram-uploader-bot/schedule"
+)
+
+const (
+	OUTSIDE_WINDOW_HOLD   = "hold"
+	OUTSIDE_WINDOW_SILENT = "silent"
+)
+
+// held is a batch of task files held until task upload window opens
+type held struct {
+	items []*queue.Item
+	timer *time.Timer
+}
+
+// location returns upload config time zone
+func location(u config.Upload) (*time.Location, error) {
+	if u.Timezone == "" {
+		return time.Local, nil
+	}
+	loc, err := time.LoadLocation(u.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timezone: %v", err)
+	}
+	return loc, nil
+}
+
+// newUploadWindow creates upload window for upload config, returns nil if window is not set
+func newUploadWindow(u config.Upload, loc *time.Location) (*schedule.Window, bool, error) {
+	if u.UploadWindow == "" {
+		return nil, false, nil
+	}
+	w, err := schedule.Parse(u.UploadWindow, loc)
+	if err != nil {
+		return nil, false, err
+	}
+	switch u.OutsideWindow {
+	case "", OUTSIDE_WINDOW_HOLD:
+		return w, false, nil
+	case OUTSIDE_WINDOW_SILENT:
+		return w, true, nil
+	}
+	return nil, false, fmt.Errorf("unknown outside upload window mode: %s", u.OutsideWindow)
+}
+
+// isSilent checks task files should be sent without notification
+func (t *Task) isSilent() bool {
+	return t.window != nil && t.silentOutsideWindow && !t.window.IsOpen(time.Now())
+}
+
+// heldUntil returns time task files are held until, or zero time if files are not held
+func (t *Task) heldUntil(now time.Time) time.Time {
+	if t.window == nil || t.silentOutsideWindow || t.window.IsOpen(now) {
+		return time.Time{}
+	}
+	return t.window.NextOpen(now)
+}
+
+// hold holds task file until given time, or until task is resumed
+// if time is zero, lanes lock must be held
+func (u *Uploader) hold(t *Task, item *queue.Item, until time.Time) {
+	h, ok := u.held[t]
+	if !ok {
+		h = &held{
+			items: make([]*queue.Item, 0),
+		}
+		if until.IsZero() {
+			glog.V(3).Infof("task [%d] is paused, holding files until resumed", t.id)
+		} else {
+			glog.V(3).Infof("task [%d] upload window %s is closed, holding files until %v", t.id, t.window, until)
+			h.timer = time.AfterFunc(time.Until(until), func() {
+				u.lanesMu.Lock()
+				defer u.lanesMu.Unlock()
+
+				u.releaseHeld(t, h)
+			})
+		}
+		u.held[t] = h
+	}
+	h.items = append(h.items, item)
+}
+
+// releaseHeld dispatches held task files, lanes lock must be held
+func (u *Uploader) releaseHeld(t *Task, h *held) {
+	if u.held[t] != h {
+		return
+	}
+	delete(u.held, t)
+
+	glog.V(3).Infof("task [%d] releasing %d held file(s)", t.id, len(h.items))
+	for _, item := range h.items {
+		u.route(t, item)
+	}
+}
+
+// pause stops uploading of task files until task is resumed,
+// uploads in progress are not interrupted
+func (u *Uploader) pause(t *Task) {
+	u.lanesMu.Lock()
+	defer u.lanesMu.Unlock()
+
+	t.paused = true
+}
+
+// resume resumes uploading of paused task files
+func (u *Uploader) resume(t *Task) {
+	u.lanesMu.Lock()
+	defer u.lanesMu.Unlock()
+
+	t.paused = false
+	if h, ok := u.held[t]; ok && h.timer == nil {
+		u.releaseHeld(t, h)
+	}
+}
+
+// isPaused checks task is paused
+func (u *Uploader) isPaused(t *Task) bool {
+	u.lanesMu.Lock()
+	defer u.lanesMu.Unlock()
+
+	return t.paused
+}
+
+// dropHeld returns held files back to the upload queue
+func (u *Uploader) dropHeld() {
+	u.lanesMu.Lock()
+	defer u.lanesMu.Unlock()
+
+	for t, h := range u.held {
+		if h.timer != nil {
+			h.timer.Stop()
+		}
+		for _, item := range h.items {
+			u.finish(item, false)
+		}
+		delete(u.held, t)
+	}
+}
@@ -0,0 +1,183 @@
+// Copyright 2023 Victor Antonovich <v.antonovich@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package uploader
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// Store key prefix for uploaded files records
+	keyPrefixSent = "sent/"
+	// Store key prefix for uploaded files content hashes
+	keyPrefixHash = "hash/"
+)
+
+type sentRecord struct {
+	Size    int64 `json:"size"`
+	ModTime int64 `json:"mtime"`
+	Time    int64 `json:"time"`
+}
+
+type hashRecord struct {
+	Path string `json:"path"`
+	Time int64  `json:"time"`
+}
+
+func sentKey(chatId int64, path string) string {
+	return fmt.Sprintf("%s%d/%s", keyPrefixSent, chatId, path)
+}
+
+// isSent checks file with given size and modification time was uploaded to the chat
+func (u *Uploader) isSent(chatId int64, path string, fi fs.FileInfo) bool {
+	if u.store == nil {
+		return false
+	}
+	v, ok := u.store.Get(sentKey(chatId, path))
+	if !ok {
+		return false
+	}
+	var r sentRecord
+	if err := json.Unmarshal([]byte(v), &r); err != nil {
+		glog.Warningf("malformed upload record for %s: %v", path, err)
+		return false
+	}
+	return r.Size == fi.Size() && r.ModTime == fi.ModTime().UnixNano()
+}
+
+// markSent records file was uploaded to the chat
+func (u *Uploader) markSent(chatId int64, path string, fi fs.FileInfo) {
+	if u.store == nil {
+		return
+	}
+	b, err := json.Marshal(sentRecord{
+		Size:    fi.Size(),
+		ModTime: fi.ModTime().UnixNano(),
+		Time:    time.Now().Unix(),
+	})
+	if err != nil {
+		glog.Errorf("can't encode upload record for %s: %v", path, err)
+		return
+	}
+	if err := u.store.Put(sentKey(chatId, path), string(b)); err != nil {
+		glog.Errorf("can't save upload record for %s: %v", path, err)
+	}
+}
+
+// pruneSent removes upload records of files not existing anymore. Records are
+// removed only for files in watched directories that can be listed and are not
+// empty, so records are kept while network filesystem is not mounted.
+func (u *Uploader) pruneSent() {
+	if u.store == nil {
+		return
+	}
+	u.mu.RLock()
+	tasks := u.tasks
+	u.mu.RUnlock()
+	dirs := make([]string, 0)
+	for _, t := range tasks {
+		dir := t.watcher.Dir()
+		if entries, err := os.ReadDir(dir); err != nil || len(entries) == 0 {
+			glog.V(2).Infof("keeping upload records of files in %s, as it's empty or can't be read", dir)
+			continue
+		}
+		dirs = append(dirs, dir)
+	}
+	n := 0
+	for _, k := range u.store.Keys(keyPrefixSent) {
+		_, path, found := strings.Cut(strings.TrimPrefix(k, keyPrefixSent), "/")
+		if !found || !isInDirs(path, dirs) {
+			continue
+		}
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			continue
+		}
+		if err := u.store.Delete(k); err != nil {
+			glog.Errorf("can't remove upload record for %s: %v", path, err)
+			return
+		}
+		n++
+	}
+	if n > 0 {
+		glog.V(2).Infof("removed %d upload record(s) of missing files", n)
+	}
+}
+
+// isInDirs checks path is in one of given directories
+func isInDirs(path string, dirs []string) bool {
+	for _, dir := range dirs {
+		if rel, err := filepath.Rel(dir, path); err == nil && filepath.IsLocal(rel) {
+			return true
+		}
+	}
+	return false
+}
+
+func hashKey(chatId int64, hash string) string {
+	return fmt.Sprintf("%s%d/%s", keyPrefixHash, chatId, hash)
+}
+
+// fileHash returns hex encoded file content hash
+func fileHash(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// findSentHash returns path of file with given content hash uploaded to the chat
+func (u *Uploader) findSentHash(chatId int64, hash string) (string, bool) {
+	v, ok := u.store.Get(hashKey(chatId, hash))
+	if !ok {
+		return "", false
+	}
+	var r hashRecord
+	if err := json.Unmarshal([]byte(v), &r); err != nil {
+		glog.Warningf("malformed content hash record %s: %v", hash, err)
+	}
+	return r.Path, true
+}
+
+// markHashSent records file with given content hash was uploaded to the chat
+func (u *Uploader) markHashSent(chatId int64, hash string, path string) {
+	b, err := json.Marshal(hashRecord{
+		Path: path,
+		Time: time.Now().Unix(),
+	})
+	if err != nil {
+		glog.Errorf("can't encode content hash record for %s: %v", path, err)
+		return
+	}
+	if err := u.store.Put(hashKey(chatId, hash), string(b)); err != nil {
+		glog.Errorf("can't save content hash record for %s: %v", path, err)
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright 2023 Victor Antonovich <v.antonovich@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package uploader
+
+import (
+	"sync"
+	"time"
+)
+
+// Max number of recent upload failures to keep
+const MAX_RECENT_FAILURES = 10
+
+// stats are uploader statistics since start
+type stats struct {
+	mu       sync.Mutex
+	started  time.Time
+	uploaded int
+	failed   int
+	last     *uploadRecord
+	failures []*uploadRecord // recent failures, the latest last
+}
+
+// uploadRecord is a file uploaded or failed to upload to the chat
+type uploadRecord struct {
+	task   string
+	path   string
+	chatId int64
+	err    error
+	time   time.Time
+}
+
+func newStats() *stats {
+	return &stats{
+		started:  time.Now(),
+		failures: make([]*uploadRecord, 0),
+	}
+}
+
+func (s *stats) addUploaded(path string, chatId int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.uploaded++
+	s.last = &uploadRecord{
+		path:   path,
+		chatId: chatId,
+		time:   time.Now(),
+	}
+}
+
+func (s *stats) addFailed(t *Task, path string, chatId int64, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.failed++
+	s.failures = append(s.failures, &uploadRecord{
+		task:   t.key,
+		path:   path,
+		chatId: chatId,
+		err:    err,
+		time:   time.Now(),
+	})
+	if len(s.failures) > MAX_RECENT_FAILURES {
+		s.failures = s.failures[1:]
+	}
+}
+
+// takeFailures returns recent failures and forgets them
+func (s *stats) takeFailures() []*uploadRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	failures := s.failures
+	s.failures = make([]*uploadRecord, 0)
+	return failures
+}
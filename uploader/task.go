@@ -0,0 +1,231 @@
+// Copyright 2023 Victor Antonovich <v.antonovich@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package uploader
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+	"golang.org/x/time/rate"
+
+	"github.com/3cky/telegram-uploader-bot/bot"
+	"github.com/3cky/telegram-uploader-bot/config"
+	"github.com/3cky/telegram-uploader-bot/imaging"
+	"github.com/3cky/telegram-uploader-bot/schedule"
+	"github.com/3cky/telegram-uploader-bot/store"
+	"github.com/3cky/telegram-uploader-bot/tagger"
+	"github.com/3cky/telegram-uploader-bot/watcher"
+)
+
+type Task struct {
+	id                  uint
+	key                 string
+	watcher             *watcher.Watcher
+	dir                 string
+	watchOpts           watcher.Options
+	backfill            bool
+	backfillMaxAge      time.Duration
+	minSize             uint64
+	maxSize             uint64
+	chatIds             []int64
+	document            bool
+	dedupe              bool
+	split               bool
+	bandwidth           *rate.Limiter
+	photoMaxDimension   int
+	jpegQuality         int
+	albumWindow         time.Duration
+	window              *schedule.Window
+	silentOutsideWindow bool
+	digest              *digest
+	taggers             []tagger.Taggable
+	retry               retryPolicy
+	onSuccess           *action
+	onFailure           *action
+	paused              bool // guarded by lanes lock
+}
+
+// newTask creates task for upload config, task watcher is created separately
+func newTask(id uint, u config.Upload, config *config.Config, st *store.Store) (*Task, error) {
+	// Check file size limits
+	minSize := u.MinSize.Bytes()
+	maxSize := u.MaxSize.Bytes()
+	if maxSize == 0 {
+		maxSize = MAX_UPLOAD_SIZE
+		if config.Telegram.Local {
+			maxSize = MAX_LOCAL_UPLOAD_SIZE
+		}
+	}
+	if minSize > maxSize {
+		return nil, fmt.Errorf("max upload size (%d) must not be less than min size (%d)", maxSize, minSize)
+	}
+
+	// Check uploaded files backfill and deduplication can be done
+	if u.Backfill && st == nil {
+		return nil, fmt.Errorf("backfill of %s requires state directory to be set", u.Directory)
+	}
+	if u.Dedupe && st == nil {
+		return nil, fmt.Errorf("deduplication of %s requires state directory to be set", u.Directory)
+	}
+
+	// Merge task chats
+	chatIds := make([]int64, 0)
+	chatIdSet := make(map[int64]bool)
+	for _, c := range append([]int64{u.ChatId}, u.ChatIds...) {
+		if c != 0 && !chatIdSet[c] {
+			chatIds = append(chatIds, c)
+			chatIdSet[c] = true
+		}
+	}
+	if len(chatIds) == 0 {
+		return nil, fmt.Errorf("no chats to upload files from %s to", u.Directory)
+	}
+
+	// Check album time window
+	if u.Album < 0 {
+		return nil, fmt.Errorf("album time window must not be negative")
+	}
+
+	// Check photo downscaling settings
+	if u.Photo.MaxDimension < 0 {
+		return nil, fmt.Errorf("photo max dimension must not be negative")
+	}
+	jpegQuality := u.Photo.JpegQuality
+	if jpegQuality == 0 {
+		jpegQuality = imaging.DefaultJpegQuality
+	}
+	if jpegQuality < 1 || jpegQuality > 100 {
+		return nil, fmt.Errorf("invalid photo JPEG quality: %d", jpegQuality)
+	}
+
+	// Create task upload window and digest
+	loc, err := location(u)
+	if err != nil {
+		return nil, err
+	}
+	window, silentOutsideWindow, err := newUploadWindow(u, loc)
+	if err != nil {
+		return nil, fmt.Errorf("upload window: %v", err)
+	}
+	digest, err := newDigest(u.Digest, loc)
+	if err != nil {
+		return nil, fmt.Errorf("digest: %v", err)
+	}
+	if digest != nil && u.Album > 0 {
+		return nil, fmt.Errorf("album and digest modes can't be used together")
+	}
+
+	// Create task retry policy
+	retry, err := newRetryPolicy(config.Retry, u.Retry)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create task post-upload file actions
+	onSuccess, err := newAction(u.OnSuccess)
+	if err != nil {
+		return nil, fmt.Errorf("on success action: %v", err)
+	}
+	onFailure, err := newAction(u.OnFailure)
+	if err != nil {
+		return nil, fmt.Errorf("on failure action: %v", err)
+	}
+	excludePatterns := append([]string{}, u.ExcludePatterns...)
+	for _, a := range []*action{onSuccess, onFailure} {
+		if err := checkActionDir(a, u); err != nil {
+			return nil, err
+		}
+		excludePatterns = append(excludePatterns, actionExcludePatterns(a)...)
+	}
+
+	// Create task taggables
+	tags := make([]tagger.Taggable, 0)
+	pt, err := tagger.NewPlainTagger(u.Tags.Plain)
+	if err != nil {
+		return nil, err
+	}
+	tags = append(tags, pt)
+
+	rt, err := tagger.NewRegexpTagger(u.Tags.Regexp)
+	if err != nil {
+		return nil, fmt.Errorf("tag regexp: %v", err)
+	}
+	tags = append(tags, rt)
+
+	et, err := tagger.NewExprTagger(u.Tags.Expr)
+	if err != nil {
+		return nil, fmt.Errorf("tag expr: %v", err)
+	}
+	tags = append(tags, et)
+
+	task := &Task{
+		id:  id,
+		key: taskKey(u),
+		dir: u.Directory,
+		watchOpts: watcher.Options{
+			Mode:            u.WatchMode,
+			PollInterval:    u.PollInterval,
+			Settle:          u.Settle,
+			Recursive:       u.Recursive,
+			FilePatterns:    u.FilePatterns,
+			ExcludePatterns: excludePatterns,
+		},
+		backfill:            u.Backfill,
+		backfillMaxAge:      u.BackfillMaxAge,
+		minSize:             minSize,
+		maxSize:             maxSize,
+		chatIds:             chatIds,
+		document:            u.Document,
+		dedupe:              u.Dedupe,
+		split:               u.Split,
+		bandwidth:           bot.NewBandwidthLimiter(u.Bandwidth.Bytes()),
+		photoMaxDimension:   u.Photo.MaxDimension,
+		jpegQuality:         jpegQuality,
+		albumWindow:         u.Album,
+		window:              window,
+		silentOutsideWindow: silentOutsideWindow,
+		digest:              digest,
+		taggers:             tags,
+		retry:               retry,
+		onSuccess:           onSuccess,
+		onFailure:           onFailure,
+	}
+
+	return task, nil
+}
+
+// watch creates task watcher sending events to given channel
+func (t *Task) watch(eventCh chan watcher.Event) error {
+	w, err := watcher.NewWatcher(t.id, eventCh, t.dir, t.watchOpts)
+	if err != nil {
+		return err
+	}
+	t.watcher = w
+	return nil
+}
+
+// watchTasks creates watchers of tasks and returns tasks with watchers created
+func watchTasks(tasks []*Task, eventCh chan watcher.Event) []*Task {
+	watched := make([]*Task, 0)
+	for _, t := range tasks {
+		if err := t.watch(eventCh); err != nil {
+			glog.Warningf("can't watch %s: %v", t.dir, err)
+			continue
+		}
+		watched = append(watched, t)
+	}
+	return watched
+}
@@ -16,127 +16,218 @@ package uploader
 
 import (
 	"context"
+	"crypto/sha1"
+	"encoding/json"
 	"fmt"
-	"os"
+	"path/filepath"
+	"sync"
+	"time"
 
 	"github.com/golang/glog"
+	"golang.org/x/time/rate"
 
 	"github.com/3cky/telegram-uploader-bot/bot"
 	"github.com/3cky/telegram-uploader-bot/config"
-	"github.com/3cky/telegram-uploader-bot/tagger"
+	"github.com/3cky/telegram-uploader-bot/queue"
+	"github.com/3cky/telegram-uploader-bot/store"
 	"github.com/3cky/telegram-uploader-bot/watcher"
 )
 
-const MAX_UPLOAD_SIZE = 50 * 1024 * 1024 // 50 MB is default Telegram API file size limit
+const (
+	MAX_UPLOAD_SIZE       = 50 * 1024 * 1024   // 50 MB is default Telegram API file size limit
+	MAX_LOCAL_UPLOAD_SIZE = 2000 * 1024 * 1024 // 2000 MB is local Telegram API server file size limit
+	DEFAULT_WORKERS       = 4
+
+	DEFAULT_SHUTDOWN_TIMEOUT = 30 * time.Second
+)
 
 type Uploader struct {
 	ctx       context.Context
 	ctxCancel context.CancelFunc
 
-	tgBot *bot.Bot
+	// Canceled on stop to stop taking new files to upload
+	drainCtx    context.Context
+	drainCancel context.CancelFunc
+
+	// Current config, Telegram bot and tasks, changed on config reload
+	config  *config.Config
+	tgBot   *bot.Bot
+	tasks   []*Task
+	nextId  uint
+	started bool
+	mu      sync.RWMutex
+
+	// Telegram bot rate limiter, kept on config reload
+	rateLimiter *bot.RateLimiter
+
+	store *store.Store
+
+	queue *queue.Queue
+
+	stats *stats
 
-	tasks []*Task
+	// Cancels receiving of bot commands, closed once receiving is stopped
+	commandsCancel context.CancelFunc
+	commandsDoneCh chan struct{}
+	// Next bot update to receive commands from, kept on bot recreation
+	commandsBotId  int64
+	commandsOffset int
+
+	// Upload jobs by chat
+	lanes   map[int64][]*job
+	albums  map[*Task]*album
+	held    map[*Task]*held
+	lanesMu sync.Mutex
+	lanesWg sync.WaitGroup
+
+	// Busy upload workers
+	workersCh chan struct{}
 
 	eventCh chan watcher.Event
 	doneCh  chan struct{}
 }
 
-type Task struct {
-	id       uint
-	watcher  *watcher.Watcher
-	minSize  uint64
-	maxSize  uint64
-	chatId   int64
-	document bool
-	taggers  []tagger.Taggable
-}
-
-func NewUploader(ctx context.Context, config *config.Config) (*Uploader, error) {
-	// Check telegram bot token is set and is not empty
-	if config.Telegram.Token == "" {
-		return nil, fmt.Errorf("telegram bot token is not set or empty")
+// NewUploader creates uploader for given config and upload queue. Persistent state
+// store is optional and could be nil, so features depending on it are not available.
+func NewUploader(ctx context.Context, config *config.Config, st *store.Store, q *queue.Queue) (*Uploader, error) {
+	// Create telegram bot
+	limits, err := rateLimits(config.Telegram.RateLimit)
+	if err != nil {
+		return nil, err
+	}
+	rateLimiter := bot.NewRateLimiter(limits)
+	tgBot, err := newBot(config.Telegram, rateLimiter)
+	if err != nil {
+		return nil, err
 	}
 
-	// Create telegram bot
-	tgBot, err := bot.NewBot(config.Telegram.Token)
+	// Check upload workers count and shutdown timeout
+	workers, err := workersCount(config)
 	if err != nil {
-		return nil, fmt.Errorf("can't create telegram bot: %v", err)
+		return nil, err
+	}
+	if _, err := shutdownTimeout(config); err != nil {
+		return nil, err
 	}
 
 	// Create watch tasks
-	eventCh := make(chan watcher.Event, 100) // FIXME make event buffer size configurable
-	tasks := make([]*Task, 0)
+	eventCh := make(chan watcher.Event, 100) // events are moved to upload queue as soon as received
+	newTasks := make([]*Task, 0)
 	var id uint
 	for _, u := range config.Uploads {
-		// Check file size limits
-		minSize := u.MinSize.Bytes()
-		maxSize := u.MaxSize.Bytes()
-		if maxSize == 0 {
-			maxSize = MAX_UPLOAD_SIZE
-		}
-		if minSize > maxSize {
-			return nil, fmt.Errorf("max upload size (%d) must not be less than min size (%d)", maxSize, minSize)
-		}
-
-		// Create task taggables
-		tags := make([]tagger.Taggable, 0)
-		pt, err := tagger.NewPlainTagger(u.Tags.Plain)
+		t, err := newTask(id, u, config, st)
 		if err != nil {
 			return nil, err
 		}
-		tags = append(tags, pt)
-
-		rt, err := tagger.NewRegexpTagger(u.Tags.Regexp)
-		if err != nil {
-			return nil, fmt.Errorf("tag regexp: %v", err)
-		}
-		tags = append(tags, rt)
-
-		et, err := tagger.NewExprTagger(u.Tags.Expr)
-		if err != nil {
-			return nil, fmt.Errorf("tag expr: %v", err)
-		}
-		tags = append(tags, et)
-
-		// Create task watcher
-		w, err := watcher.NewWatcher(id, eventCh, u.Directory, u.FilePatterns)
-		if err != nil {
-			glog.Warningf("can't watch %s: %v", u.Directory, err)
-			continue
-		}
-
-		task := &Task{
-			id:       id,
-			watcher:  w,
-			minSize:  minSize,
-			maxSize:  maxSize,
-			chatId:   u.ChatId,
-			document: u.Document,
-			taggers:  tags,
-		}
-		tasks = append(tasks, task)
-
+		newTasks = append(newTasks, t)
 		id++
 	}
+	tasks := watchTasks(newTasks, eventCh)
 	if len(tasks) == 0 {
 		return nil, fmt.Errorf("no directories to watch for new files")
 	}
 
 	// Create cancelable context
 	ctxWithCancel, ctxCancel := context.WithCancel(ctx)
+	drainCtx, drainCancel := context.WithCancel(ctxWithCancel)
 
 	doneCh := make(chan struct{})
 
 	return &Uploader{
-		ctx:       ctxWithCancel,
-		ctxCancel: ctxCancel,
-		tgBot:     tgBot,
-		tasks:     tasks,
-		eventCh:   eventCh,
-		doneCh:    doneCh,
+		ctx:         ctxWithCancel,
+		ctxCancel:   ctxCancel,
+		drainCtx:    drainCtx,
+		drainCancel: drainCancel,
+		config:      config,
+		tgBot:       tgBot,
+		tasks:       tasks,
+		nextId:      id,
+		rateLimiter: rateLimiter,
+		store:       st,
+		queue:       q,
+		stats:       newStats(),
+		lanes:       make(map[int64][]*job),
+		albums:      make(map[*Task]*album),
+		held:        make(map[*Task]*held),
+		workersCh:   make(chan struct{}, workers),
+		eventCh:     eventCh,
+		doneCh:      doneCh,
 	}, nil
 }
 
+// newBot creates Telegram bot for given settings and rate limiter
+func newBot(c config.Telegram, rl *bot.RateLimiter) (*bot.Bot, error) {
+	// Check telegram bot token is set and is not empty
+	if c.Token == "" {
+		return nil, fmt.Errorf("telegram bot token is not set or empty")
+	}
+
+	tgBot, err := bot.NewBot(c.Token, bot.Options{
+		ApiEndpoint: c.ApiEndpoint,
+		Local:       c.Local,
+		Bandwidth:   c.Bandwidth.Bytes(),
+		RateLimiter: rl,
+		DryRun:      c.DryRun,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can't create telegram bot: %v", err)
+	}
+	return tgBot, nil
+}
+
+// rateLimits returns bot rate limits for config, using default limits for unset ones
+func rateLimits(c config.RateLimits) (bot.RateLimits, error) {
+	limits := bot.DefaultRateLimits
+	for _, l := range []struct {
+		name   string
+		config config.RateLimit
+		limit  *bot.RateLimit
+	}{
+		{"global", c.Global, &limits.Global},
+		{"group", c.Group, &limits.Group},
+		{"private", c.Private, &limits.Private},
+	} {
+		if l.config.Messages < 0 || l.config.Per < 0 || l.config.Burst < 0 {
+			return limits, fmt.Errorf("%s rate limit must not be negative", l.name)
+		}
+		if l.config.Messages > 0 {
+			per := l.config.Per
+			if per == 0 {
+				per = time.Second
+			}
+			l.limit.Limit = rate.Limit(float64(l.config.Messages) / per.Seconds())
+		}
+		if l.config.Burst > 0 {
+			l.limit.Burst = l.config.Burst
+		}
+	}
+	return limits, nil
+}
+
+// workersCount returns checked upload workers count
+func workersCount(config *config.Config) (int, error) {
+	workers := config.Workers
+	if workers < 0 {
+		return 0, fmt.Errorf("upload workers count must not be negative")
+	}
+	if workers == 0 {
+		workers = DEFAULT_WORKERS
+	}
+	return workers, nil
+}
+
+// shutdownTimeout returns checked shutdown timeout
+func shutdownTimeout(config *config.Config) (time.Duration, error) {
+	timeout := config.ShutdownTimeout
+	if timeout < 0 {
+		return 0, fmt.Errorf("shutdown timeout must not be negative")
+	}
+	if timeout == 0 {
+		timeout = DEFAULT_SHUTDOWN_TIMEOUT
+	}
+	return timeout, nil
+}
+
 func (u *Uploader) Start() {
 	glog.V(1).Infoln("file uploader started")
 
@@ -145,51 +236,171 @@ func (u *Uploader) Start() {
 	defer u.ctxCancel()
 	defer glog.V(1).Infoln("file uploader stopped")
 
-	for _, t := range u.tasks {
+	u.pruneSent()
+
+	enqueueDoneCh := make(chan struct{})
+	go func() {
+		u.enqueue()
+		close(enqueueDoneCh)
+	}()
+
+	u.mu.Lock()
+	u.startTasks(u.tasks)
+	u.startCommands()
+	u.started = true
+	u.mu.Unlock()
+
+	for {
+		item, err := u.queue.Pop(u.drainCtx)
+		if err != nil {
+			// Return collected files back to the queue
+			// and wait for uploads in progress
+			u.dropHeld()
+			u.dropAlbums()
+			u.lanesWg.Wait()
+			<-enqueueDoneCh
+			return
+		}
+		u.dispatch(item)
+	}
+}
+
+// startTasks starts task watchers and backfills
+func (u *Uploader) startTasks(tasks []*Task) {
+	for _, t := range tasks {
 		go t.watcher.Start()
 	}
 
+	// Enqueue files appeared while uploader wasn't running
+	for _, t := range tasks {
+		if t.backfill {
+			go u.backfill(t)
+		}
+	}
+}
+
+// enqueue moves watcher events to the upload queue
+func (u *Uploader) enqueue() {
 	for {
 		select {
-		case e := <-u.eventCh:
-			fp := e.Path
-			glog.V(4).Infof("new file to upload: %s", fp)
-			t := u.tasks[e.Id]
-			// Check file size
-			fi, err := os.Stat(fp)
-			if err != nil {
-				glog.Errorf("can't stat file to upload %s: %v", fp, err)
-				continue
-			}
-			if fi.Size() < int64(t.minSize) {
-				glog.V(3).Infof("skipping uploading of too small file (%d byte(s)): %s", fi.Size(), fp)
-				continue
+		case e, ok := <-u.eventCh:
+			if !ok {
+				return
 			}
-			if fi.Size() > int64(t.maxSize) {
-				glog.Warningf("skipping uploading of too big file (%d byte(s)): %s", fi.Size(), fp)
-				continue
-			}
-			// Get file tags
-			tags := make([]string, 0)
-			for _, tg := range t.taggers {
-				tags = append(tags, tg.Tags(fp)...)
+			u.push(e)
+			continue
+		case <-u.drainCtx.Done():
+			// Move events sent before watchers were stopped to the queue
+			for {
+				select {
+				case e := <-u.eventCh:
+					u.push(e)
+				default:
+					return
+				}
 			}
-			// Upload file to Telegram
-			if err := u.tgBot.UploadFile(u.ctx, t.chatId, fp, t.document, tags...); err != nil {
-				glog.Errorf("can't upload file %s to chat %d: %v", e.Path, t.chatId, err)
+		}
+	}
+}
+
+func (u *Uploader) push(e watcher.Event) {
+	glog.V(4).Infof("new file to upload: %s", e.Path)
+	// Event of task removed on config reload is queued without
+	// task key, so it's uploaded by task watching the file directory
+	var key string
+	u.mu.RLock()
+	for _, t := range u.tasks {
+		if t.id == e.Id {
+			key = t.key
+		}
+	}
+	u.mu.RUnlock()
+	if err := u.queue.Push(key, e.Path); err != nil {
+		glog.Errorf("can't add %s to upload queue: %v", e.Path, err)
+	}
+}
+
+// findTask returns task queued item belongs to. If there is no such task
+// (i.e. task config was changed since the item was queued), returns the
+// task watching directory containing item file. Error is returned if there
+// is no such task or there are multiple ones, as it's unknown which one
+// the item should be uploaded by.
+func (u *Uploader) findTask(item *queue.Item) (*Task, error) {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+
+	for _, t := range u.tasks {
+		if t.key == item.Task {
+			return t, nil
+		}
+	}
+	var task *Task
+	for _, t := range u.tasks {
+		if rel, err := filepath.Rel(t.watcher.Dir(), item.Path); err == nil && filepath.IsLocal(rel) {
+			if task != nil {
+				return nil, fmt.Errorf("file is in directories watched by tasks [%d] and [%d]", task.id, t.id)
 			}
-			continue
-		case <-u.ctx.Done():
-			return
+			task = t
 		}
 	}
+	if task == nil {
+		return nil, fmt.Errorf("file is not in any directory to watch")
+	}
+	return task, nil
 }
 
+// isDryRun checks files are not actually sent, so uploader
+// state is not changed and post-upload actions are not done
+func (u *Uploader) isDryRun() bool {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+
+	return u.config.Telegram.DryRun
+}
+
+// bot returns current Telegram bot
+func (u *Uploader) bot() *bot.Bot {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+
+	return u.tgBot
+}
+
+// Stop stops watching for new files and waits for uploads in progress
+// to finish, interrupting them on shutdown timeout. Files not uploaded,
+// including files not emitted by watchers yet, are kept in the upload queue.
 func (u *Uploader) Stop() {
 	glog.V(1).Infoln("stopping file uploader...")
-	for _, t := range u.tasks {
+	u.mu.RLock()
+	tasks := u.tasks
+	timeout, _ := shutdownTimeout(u.config)
+	u.mu.RUnlock()
+
+	for _, t := range tasks {
 		t.watcher.Stop()
 	}
-	u.ctxCancel()
-	<-u.doneCh
+	u.drainCancel()
+	select {
+	case <-u.doneCh:
+	case <-time.After(timeout):
+		glog.Warningf("uploads in progress are not finished in %v, interrupting them", timeout)
+		u.ctxCancel()
+		<-u.doneCh
+	}
+
+	// Queue files still changing or settling after uploader is stopped,
+	// so they are uploaded on next start instead of now
+	for _, t := range tasks {
+		for _, path := range t.watcher.Pending() {
+			u.push(watcher.Event{Id: t.id, Path: path})
+		}
+	}
+}
+
+// taskKey returns upload config identity for persisted task related data.
+// Config is JSON encoded, so values of optional settings are hashed
+// instead of their pointers and key is kept between restarts.
+func taskKey(u config.Upload) string {
+	b, _ := json.Marshal(u) // upload config has no values can't be encoded
+	return fmt.Sprintf("%x", sha1.Sum(b))
 }
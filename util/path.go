@@ -0,0 +1,65 @@
+// Copyright 2023 Victor Antonovich <v.antonovich@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"path"
+	"strings"
+)
+
+// MatchPath reports whether slash-separated name matches the shell pattern.
+// In addition to path.Match syntax, the pattern may contain "**" elements
+// matching zero or more path elements.
+func MatchPath(pattern, name string) (bool, error) {
+	return matchPathElems(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+// ValidatePathPattern checks pattern syntax.
+func ValidatePathPattern(pattern string) error {
+	for _, p := range strings.Split(pattern, "/") {
+		if _, err := path.Match(p, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func matchPathElems(patterns, names []string) (bool, error) {
+	for len(patterns) > 0 {
+		if patterns[0] == "**" {
+			// Skip repeated "**" elements
+			for len(patterns) > 0 && patterns[0] == "**" {
+				patterns = patterns[1:]
+			}
+			if len(patterns) == 0 {
+				return true, nil
+			}
+			for i := range names {
+				if matched, err := matchPathElems(patterns, names[i:]); matched || err != nil {
+					return matched, err
+				}
+			}
+			return false, nil
+		}
+		if len(names) == 0 {
+			return false, nil
+		}
+		if matched, err := path.Match(patterns[0], names[0]); !matched || err != nil {
+			return matched, err
+		}
+		patterns, names = patterns[1:], names[1:]
+	}
+	return len(names) == 0, nil
+}
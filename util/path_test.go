@@ -0,0 +1,81 @@
+// Copyright 2023 Victor Antonovich <v.antonovich@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import "testing"
+
+func TestMatchPath(t *testing.T) {
+	for _, tc := range []struct {
+		pattern string
+		name    string
+		matched bool
+	}{
+		{"*.jpg", "a.jpg", true},
+		{"*.jpg", "a.png", false},
+		{"*.jpg", "raw/a.jpg", false},
+		{"raw/*.jpg", "raw/a.jpg", true},
+		{"raw/*.jpg", "raw/2023/a.jpg", false},
+		{"raw/*.jpg", "a.jpg", false},
+		{"raw/?.jpg", "raw/a.jpg", true},
+		{"raw/[a-c].jpg", "raw/d.jpg", false},
+		// "**" matches zero or more path elements
+		{"raw/**/*.jpg", "raw/a.jpg", true},
+		{"raw/**/*.jpg", "raw/2023/a.jpg", true},
+		{"raw/**/*.jpg", "raw/2023/01/a.jpg", true},
+		{"raw/**/*.jpg", "raw/2023/01/a.png", false},
+		{"raw/**/*.jpg", "cooked/2023/a.jpg", false},
+		{"**/*.jpg", "a.jpg", true},
+		{"**/*.jpg", "raw/2023/a.jpg", true},
+		{"**/raw/*.jpg", "2023/raw/a.jpg", true},
+		{"**/raw/*.jpg", "2023/raw/01/a.jpg", false},
+		{"raw/**", "raw/2023/a.jpg", true},
+		{"raw/**", "raw", true},
+		{"raw/**", "cooked/a.jpg", false},
+		{"raw/**/**/*.jpg", "raw/a.jpg", true},
+		{"raw/**/01/*.jpg", "raw/2023/01/a.jpg", true},
+		{"raw/**/01/*.jpg", "raw/01/a.jpg", true},
+		{"raw/**/01/*.jpg", "raw/2023/02/a.jpg", false},
+		// "**" is not special within path element
+		{"raw/a**.jpg", "raw/abc.jpg", true},
+		{"raw/a**.jpg", "raw/a/b.jpg", false},
+	} {
+		matched, err := MatchPath(tc.pattern, tc.name)
+		if err != nil {
+			t.Errorf("MatchPath(%q, %q): %v", tc.pattern, tc.name, err)
+			continue
+		}
+		if matched != tc.matched {
+			t.Errorf("MatchPath(%q, %q) = %v, expected %v", tc.pattern, tc.name, matched, tc.matched)
+		}
+	}
+}
+
+func TestValidatePathPattern(t *testing.T) {
+	for _, tc := range []struct {
+		pattern string
+		valid   bool
+	}{
+		{"*.jpg", true},
+		{"raw/**/*.jpg", true},
+		{"raw/[a-c].jpg", true},
+		{"[", false},
+		{"raw/[a-", false},
+		{"raw/**/\\", false},
+	} {
+		if err := ValidatePathPattern(tc.pattern); (err == nil) != tc.valid {
+			t.Errorf("ValidatePathPattern(%q) = %v, expected valid: %v", tc.pattern, err, tc.valid)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+// Copyright 2023 Victor Antonovich <v.antonovich@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package watcher
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/3cky/telegram-uploader-bot/util"
+)
+
+// Suffixes of temporary files written by downloaders and file transfer tools.
+// Such files are renamed to the final name when complete, so they are ignored
+// and the file is emitted on rename.
+var tempFileSuffixes = []string{
+	".part", ".partial", ".crdownload", ".download", ".filepart",
+	".tmp", ".temp", ".!qb", ".swp", "~",
+}
+
+// matcher checks file paths against include and exclude patterns.
+// Patterns containing a path separator are matched against file path relative
+// to the watched directory, other patterns are matched against file name.
+// Exclude patterns ending with a path separator are matched against
+// file parent directories. Match is case insensitive.
+type matcher struct {
+	dir         string
+	includes    []string
+	excludes    []string
+	excludeDirs []string
+}
+
+func newMatcher(dir string, includes, excludes []string) (*matcher, error) {
+	m := &matcher{
+		dir: dir,
+	}
+
+	normalize := func(p string) (string, error) {
+		p = strings.ToLower(filepath.ToSlash(p))
+		if err := util.ValidatePathPattern(strings.TrimSuffix(p, "/")); err != nil {
+			return "", fmt.Errorf("invaild file name pattern: %s", p)
+		}
+		return p, nil
+	}
+
+	for _, p := range includes {
+		np, err := normalize(p)
+		if err != nil {
+			return nil, err
+		}
+		m.includes = append(m.includes, np)
+	}
+
+	for _, p := range excludes {
+		np, err := normalize(p)
+		if err != nil {
+			return nil, err
+		}
+		if strings.HasSuffix(np, "/") {
+			m.excludeDirs = append(m.excludeDirs, strings.TrimSuffix(np, "/"))
+		} else {
+			m.excludes = append(m.excludes, np)
+		}
+	}
+
+	return m, nil
+}
+
+// equal checks matcher matches the same files as other one
+func (m *matcher) equal(other *matcher) bool {
+	return reflect.DeepEqual(m, other)
+}
+
+func (m *matcher) isMatched(path string) bool {
+	name, relPath := m.names(path)
+
+	if isTempFile(name) {
+		return false
+	}
+
+	// Check file parent directories are not excluded
+	if i := strings.LastIndexByte(relPath, '/'); i > 0 {
+		elems := strings.Split(relPath[:i], "/")
+		for i := range elems {
+			if m.matchExcludeDirs(elems[i], strings.Join(elems[:i+1], "/")) {
+				return false
+			}
+		}
+	}
+
+	for _, p := range m.excludes {
+		if matchPattern(p, name, relPath) {
+			return false
+		}
+	}
+
+	if len(m.includes) == 0 {
+		return true
+	}
+	for _, p := range m.includes {
+		if matchPattern(p, name, relPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// isDirExcluded checks directory with given path and its contents are excluded
+func (m *matcher) isDirExcluded(path string) bool {
+	name, relPath := m.names(path)
+	return m.matchExcludeDirs(name, relPath)
+}
+
+func (m *matcher) matchExcludeDirs(name, relPath string) bool {
+	for _, p := range m.excludeDirs {
+		if matchPattern(p, name, relPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// names returns lower case file name and slash-separated path
+// relative to the watched directory
+func (m *matcher) names(path string) (string, string) {
+	name := strings.ToLower(filepath.Base(path))
+	relPath, err := filepath.Rel(m.dir, path)
+	if err != nil {
+		return name, name
+	}
+	return name, strings.ToLower(filepath.ToSlash(relPath))
+}
+
+func matchPattern(pattern, name, relPath string) bool {
+	n := name
+	if strings.ContainsRune(pattern, '/') {
+		n = relPath
+	}
+	matched, _ := util.MatchPath(pattern, n)
+	return matched
+}
+
+func isTempFile(name string) bool {
+	for _, s := range tempFileSuffixes {
+		if strings.HasSuffix(name, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,94 @@
+// Copyright 2023 Victor Antonovich <v.antonovich@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package watcher
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/golang/glog"
+
+	"github.com/rjeczalik/notify"
+)
+
+func newNotifyCh(d string, recursive bool) (chan notify.EventInfo, error) {
+	notifyCh := make(chan notify.EventInfo, 3)
+	var err error
+	if recursive {
+		// Directory creation events are needed to pick up files
+		// created before new subdirectory watch is set up
+		err = notify.Watch(filepath.Join(d, "..."), notifyCh, notify.InCloseWrite, notify.InMovedTo, notify.InCreate)
+	} else {
+		err = notify.Watch(d, notifyCh, notify.InCloseWrite, notify.InMovedTo)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return notifyCh, nil
+}
+
+// notify emits events for files reported by filesystem notifications.
+// Directory is watched only while watcher is running, so directory of
+// stopped watcher is not watched by it along with its replacement.
+func (w *Watcher) notify() {
+	notifyCh, err := newNotifyCh(w.dir, w.recursive)
+	if err != nil {
+		glog.Errorf("watcher [%d] can't watch %s: %v", w.id, w.dir, err)
+		return
+	}
+	w.notifyCh = notifyCh
+	defer notify.Stop(w.notifyCh)
+
+	// Files not emitted by replaced watcher could be still written
+	for _, path := range w.resumed {
+		if w.settleCh != nil {
+			w.sendFound(path)
+		} else {
+			w.sendEvent(path)
+		}
+	}
+
+	for {
+		select {
+		case e := <-w.notifyCh:
+			glog.V(4).Infof("watcher [%d] event: %v", w.id, e)
+			path := e.Path()
+			if w.recursive && e.Event() != notify.InCloseWrite {
+				if fi, err := os.Stat(path); err == nil && fi.IsDir() {
+					if w.matcher.isDirExcluded(path) {
+						continue
+					}
+					// Subdirectory created or moved in, emit files already there
+					err := w.files(path, func(p string, _ fs.FileInfo) {
+						w.sendFound(p)
+					})
+					if err != nil {
+						glog.Warningf("watcher [%d] can't read %s: %v", w.id, path, err)
+					}
+					continue
+				}
+			}
+			if e.Event() == notify.InCreate {
+				// File creation events are only used to track new subdirectories
+				continue
+			}
+			w.emit(path)
+			continue
+		case <-w.stopCh:
+			return
+		}
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright 2023 Victor Antonovich <v.antonovich@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package watcher
+
+import (
+	"io/fs"
+	"sort"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+type fileState struct {
+	size    int64
+	modTime int64
+}
+
+// poll emits events for new or changed files found by periodic directory listing.
+// Since there is no way to know if file is still being written, changed file is
+// only emitted when its size and modification time are unchanged for next poll.
+// Failed listing is skipped, so files not listed are not reported as new later.
+func (w *Watcher) poll() {
+	ticker := time.NewTicker(w.pollInterval)
+	defer ticker.Stop()
+
+	// Files existing at watcher start are not emitted, unless they are
+	// not listed by replaced watcher or are not emitted by it yet
+	files := w.polled
+	if files == nil {
+		var err error
+		if files, err = w.listFiles(); err != nil {
+			glog.Warningf("watcher [%d] can't list %s: %v", w.id, w.dir, err)
+			files = nil
+		}
+	}
+	changed := make(map[string]bool)
+	for _, path := range w.resumed {
+		changed[path] = true
+	}
+
+	for {
+		select {
+		case <-ticker.C:
+			current, err := w.listFiles()
+			if err != nil {
+				glog.Warningf("watcher [%d] can't list %s: %v", w.id, w.dir, err)
+				continue
+			}
+			if files == nil {
+				// No files listed at watcher start yet
+				files = current
+				continue
+			}
+			for path, st := range current {
+				if prev, ok := files[path]; ok && prev == st {
+					if changed[path] {
+						delete(changed, path)
+						w.send(path)
+					}
+					continue
+				}
+				glog.V(4).Infof("watcher [%d] file changed: %s", w.id, path)
+				changed[path] = true
+			}
+			// Forget changed files removed before they have been emitted
+			for path := range changed {
+				if _, ok := current[path]; !ok {
+					delete(changed, path)
+				}
+			}
+			files = current
+			continue
+		case <-w.stopCh:
+			// Keep state for watcher replacing this one
+			w.polled = files
+			for path := range changed {
+				w.polling = append(w.polling, path)
+			}
+			sort.Strings(w.polling)
+			return
+		}
+	}
+}
+
+func (w *Watcher) listFiles() (map[string]fileState, error) {
+	files := make(map[string]fileState)
+	err := w.files(w.dir, func(path string, fi fs.FileInfo) {
+		files[path] = fileState{
+			size:    fi.Size(),
+			modTime: fi.ModTime().UnixNano(),
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
@@ -0,0 +1,133 @@
+// Copyright 2023 Victor Antonovich <v.antonovich@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package watcher
+
+import (
+	"os"
+	"sort"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// Minimal interval between settling files checks
+const minSettleCheckInterval = 100 * time.Millisecond
+
+type settlingFile struct {
+	path     string
+	state    fileState
+	deadline time.Time
+}
+
+// settle emits files once their size and modification time are unchanged
+// for settle period. Repeated events for the same file are coalesced.
+// If settle time is not set, only files found in new subdirectories are
+// settled, and written files are emitted at once, coalesced with them.
+func (w *Watcher) settle(doneCh chan struct{}) {
+	defer close(doneCh)
+
+	settleTime := w.settleTime
+	if settleTime == 0 {
+		settleTime = DefaultWalkSettle
+	}
+	checkInterval := settleTime / 4
+	if checkInterval < minSettleCheckInterval {
+		checkInterval = minSettleCheckInterval
+	}
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+
+	files := make(map[string]*settlingFile)
+
+	for {
+		select {
+		case path := <-w.settleCh:
+			state, err := statFile(path)
+			if err != nil {
+				glog.V(4).Infof("watcher [%d] can't stat %s: %v", w.id, path, err)
+				continue
+			}
+			if _, ok := files[path]; ok {
+				glog.V(4).Infof("watcher [%d] coalescing event for settling file: %s", w.id, path)
+			}
+			files[path] = &settlingFile{
+				path:     path,
+				state:    state,
+				deadline: time.Now().Add(settleTime),
+			}
+			continue
+		case path := <-w.closedCh:
+			if _, ok := files[path]; ok {
+				glog.V(4).Infof("watcher [%d] coalescing event for settling file: %s", w.id, path)
+				delete(files, path)
+			}
+			w.sendEvent(path)
+			continue
+		case now := <-ticker.C:
+			settled := make([]*settlingFile, 0)
+			for path, f := range files {
+				if now.Before(f.deadline) {
+					continue
+				}
+				state, err := statFile(path)
+				if err != nil {
+					// File is removed while settling
+					delete(files, path)
+					continue
+				}
+				if state != f.state {
+					// File is changed, wait for next settle period
+					f.state = state
+					f.deadline = now.Add(settleTime)
+					continue
+				}
+				delete(files, path)
+				settled = append(settled, f)
+			}
+			// Keep files order
+			sort.Slice(settled, func(i, j int) bool {
+				return settled[i].deadline.Before(settled[j].deadline)
+			})
+			for _, f := range settled {
+				w.sendEvent(f.path)
+			}
+			continue
+		case <-w.stopCh:
+			// Keep files not settled yet in their order
+			settling := make([]*settlingFile, 0, len(files))
+			for _, f := range files {
+				settling = append(settling, f)
+			}
+			sort.Slice(settling, func(i, j int) bool {
+				return settling[i].deadline.Before(settling[j].deadline)
+			})
+			for _, f := range settling {
+				w.settling = append(w.settling, f.path)
+			}
+			return
+		}
+	}
+}
+
+func statFile(path string) (fileState, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return fileState{}, err
+	}
+	return fileState{
+		size:    fi.Size(),
+		modTime: fi.ModTime().UnixNano(),
+	}, nil
+}
@@ -16,23 +16,66 @@ package watcher
 
 import (
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
-	"strings"
+	"time"
 
 	"github.com/golang/glog"
 
 	"github.com/rjeczalik/notify"
 )
 
+const (
+	ModeNotify = "notify"
+	ModePoll   = "poll"
+
+	DefaultPollInterval = 10 * time.Second
+
+	// Quiet period of files found in new subdirectories, if settle time is not set
+	DefaultWalkSettle = time.Second
+)
+
 type Watcher struct {
 	id           uint
 	dir          string
-	filePatterns []string
+	mode         string
+	pollInterval time.Duration
+	settleTime   time.Duration
+	recursive    bool
+	matcher      *matcher
 	notifyCh     chan notify.EventInfo
+	settleCh     chan string
+	closedCh     chan string
 	eventCh      chan Event
 	stopCh       chan struct{}
 	doneCh       chan struct{}
+
+	// Files to emit on start, not emitted by stopped watcher replaced by this one
+	resumed []string
+	// Last directory listing in poll mode, kept on stop
+	polled map[string]fileState
+	// Files not emitted yet on stop
+	polling  []string
+	settling []string
+}
+
+type Options struct {
+	// Watch mode, filesystem notifications (default) or directory polling
+	Mode string
+	// Directory polling interval in poll mode
+	PollInterval time.Duration
+	// Quiet period file size and modification time must be unchanged
+	// for before file is emitted, zero to emit files immediately
+	Settle time.Duration
+	// Watch subdirectories, including ones created after watcher start
+	Recursive bool
+	// File name patterns, patterns containing a path separator are
+	// matched against file path relative to the watched directory
+	FilePatterns []string
+	// File name patterns to ignore, patterns ending with a path separator
+	// are matched against directories
+	ExcludePatterns []string
 }
 
 type Event struct {
@@ -40,7 +83,7 @@ type Event struct {
 	Path string
 }
 
-func NewWatcher(id uint, eventCh chan Event, d string, filePatterns []string) (*Watcher, error) {
+func NewWatcher(id uint, eventCh chan Event, d string, opts Options) (*Watcher, error) {
 	// Check d exist and is a directory
 	fileinfo, err := os.Stat(d)
 	if err != nil {
@@ -50,28 +93,69 @@ func NewWatcher(id uint, eventCh chan Event, d string, filePatterns []string) (*
 		return nil, fmt.Errorf("not a directory: %s", d)
 	}
 
-	// Validate file name patterns
-	for _, fp := range filePatterns {
-		if _, err := filepath.Match(fp, d); err != nil {
-			return nil, fmt.Errorf("invaild file name pattern: %s", fp)
-		}
+	// Event paths are reported for the resolved directory path
+	d, err = filepath.Abs(d)
+	if err != nil {
+		return nil, err
+	}
+	d, err = filepath.EvalSymlinks(d)
+	if err != nil {
+		return nil, err
 	}
 
-	// Create filesystem watcher
-	notifyCh := make(chan notify.EventInfo, 3)
-	err = notify.Watch(d, notifyCh, notify.InCloseWrite, notify.InMovedTo)
+	// Validate file name patterns
+	matcher, err := newMatcher(d, opts.FilePatterns, opts.ExcludePatterns)
 	if err != nil {
 		return nil, err
 	}
 
+	// Check watch mode
+	mode := opts.Mode
+	if mode == "" {
+		mode = ModeNotify
+	}
+	pollInterval := opts.PollInterval
+	if pollInterval == 0 {
+		pollInterval = DefaultPollInterval
+	}
+
+	switch mode {
+	case ModeNotify:
+	case ModePoll:
+		if pollInterval < 0 {
+			return nil, fmt.Errorf("invalid poll interval: %v", pollInterval)
+		}
+	default:
+		return nil, fmt.Errorf("unknown watch mode: %s", mode)
+	}
+
+	if opts.Settle < 0 {
+		return nil, fmt.Errorf("invalid settle time: %v", opts.Settle)
+	}
+	// Files found in new subdirectories are settled even if settle time is
+	// not set, as they could be still written, so settled files are coalesced
+	// with written files events
+	var settleCh, closedCh chan string
+	if opts.Settle > 0 || (mode == ModeNotify && opts.Recursive) {
+		settleCh = make(chan string, 100)
+	}
+	if opts.Settle == 0 && settleCh != nil {
+		closedCh = make(chan string, 100)
+	}
+
 	stopCh := make(chan struct{})
 	doneCh := make(chan struct{})
 
 	return &Watcher{
 		id:           id,
 		dir:          d,
-		filePatterns: filePatterns,
-		notifyCh:     notifyCh,
+		mode:         mode,
+		pollInterval: pollInterval,
+		settleTime:   opts.Settle,
+		recursive:    opts.Recursive,
+		matcher:      matcher,
+		settleCh:     settleCh,
+		closedCh:     closedCh,
 		eventCh:      eventCh,
 		stopCh:       stopCh,
 		doneCh:       doneCh,
@@ -79,46 +163,173 @@ func NewWatcher(id uint, eventCh chan Event, d string, filePatterns []string) (*
 }
 
 func (w *Watcher) Start() {
-	glog.V(3).Infof("starting watcher [%d] (%s)", w.id, w.dir)
+	glog.V(3).Infof("starting watcher [%d] (%s, %s)", w.id, w.dir, w.mode)
 
-	defer notify.Stop(w.notifyCh)
 	defer close(w.doneCh)
 	defer glog.V(3).Infof("watcher [%d] stopped", w.id)
 
-	nameMatcher := func(name string) bool {
-		if len(w.filePatterns) == 0 {
-			return true
-		}
-		for _, fp := range w.filePatterns {
-			// Case insensitive file name pattern match
-			if matched, _ := filepath.Match(strings.ToLower(fp), strings.ToLower(name)); matched {
-				return true
-			}
-		}
-		return false
+	if w.settleCh != nil {
+		settleDoneCh := make(chan struct{})
+		go w.settle(settleDoneCh)
+		defer func() { <-settleDoneCh }()
 	}
 
-	for {
-		select {
-		case e := <-w.notifyCh:
-			glog.V(4).Infof("watcher [%d] event: %v", w.id, e)
-			path := e.Path()
-			name := filepath.Base(path)
-			if matched := nameMatcher(name); matched {
-				w.eventCh <- Event{
-					Id:   w.id,
-					Path: path,
-				}
-			}
-			continue
-		case <-w.stopCh:
-			return
-		}
+	if w.mode == ModePoll {
+		w.poll()
+	} else {
+		w.notify()
 	}
 }
 
+// Stop stops the watcher. Files seen by the watcher but not emitted yet
+// are kept and could be got by Pending.
 func (w *Watcher) Stop() {
 	glog.V(3).Infof("stopping watcher [%d] (%s)", w.id, w.dir)
 	close(w.stopCh)
 	<-w.doneCh
 }
+
+// Dir returns watched directory resolved path.
+func (w *Watcher) Dir() string {
+	return w.dir
+}
+
+// Pending returns files seen by stopped watcher but not emitted yet,
+// as they have been changing or watcher has been waiting for them to settle.
+func (w *Watcher) Pending() []string {
+	pending := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, path := range append(append([]string{}, w.settling...), w.polling...) {
+		if !seen[path] {
+			pending = append(pending, path)
+			seen[path] = true
+		}
+	}
+	return pending
+}
+
+// Resume makes watcher emit files not emitted by stopped watcher it replaces,
+// if they are watched by this watcher. If both watchers poll the same files,
+// the last directory listing of stopped watcher is used as initial one, so
+// files appeared while watchers are replaced are not missed. Must be called
+// before watcher start.
+func (w *Watcher) Resume(stopped *Watcher) {
+	resumed := make(map[string]bool)
+	for _, path := range w.resumed {
+		resumed[path] = true
+	}
+	for _, path := range stopped.Pending() {
+		if !resumed[path] && w.isWatched(path) {
+			w.resumed = append(w.resumed, path)
+			resumed[path] = true
+		}
+	}
+	if w.mode == ModePoll && stopped.mode == ModePoll && stopped.polled != nil &&
+		w.dir == stopped.dir && w.recursive == stopped.recursive && w.matcher.equal(stopped.matcher) {
+		w.polled = stopped.polled
+	}
+}
+
+// isWatched checks file with given path is watched by watcher
+func (w *Watcher) isWatched(path string) bool {
+	rel, err := filepath.Rel(w.dir, path)
+	if err != nil || !filepath.IsLocal(rel) {
+		return false
+	}
+	if !w.recursive && filepath.Dir(path) != w.dir {
+		return false
+	}
+	return w.matcher.isMatched(path)
+}
+
+// Walk calls fn for every matched regular file in watched directory.
+// Error is returned if some directories can't be read.
+func (w *Watcher) Walk(fn func(path string, fi fs.FileInfo)) error {
+	return w.files(w.dir, fn)
+}
+
+func (w *Watcher) emit(path string) {
+	if matched := w.matcher.isMatched(path); matched {
+		w.send(path)
+	}
+}
+
+func (w *Watcher) send(path string) {
+	if w.settleCh == nil {
+		w.sendEvent(path)
+		return
+	}
+	ch := w.settleCh
+	if w.closedCh != nil {
+		// File is written, so it's emitted without settling
+		ch = w.closedCh
+	}
+	select {
+	case ch <- path:
+	case <-w.stopCh:
+	}
+}
+
+// sendFound sends file found in new subdirectory, which could be still written
+func (w *Watcher) sendFound(path string) {
+	select {
+	case w.settleCh <- path:
+	case <-w.stopCh:
+	}
+}
+
+func (w *Watcher) sendEvent(path string) {
+	select {
+	case w.eventCh <- Event{Id: w.id, Path: path}:
+	case <-w.stopCh:
+	}
+}
+
+// files calls fn for every matched regular file in dir (and its
+// subdirectories, if watcher is recursive). Files of directories can't
+// be read are skipped and the first directory read error is returned.
+func (w *Watcher) files(dir string, fn func(path string, fi fs.FileInfo)) error {
+	visit := func(path string, d fs.DirEntry) {
+		if !d.Type().IsRegular() || !w.matcher.isMatched(path) {
+			return
+		}
+		fi, err := d.Info()
+		if err != nil {
+			// File could be removed since directory read
+			glog.V(4).Infof("watcher [%d] can't stat %s: %v", w.id, path, err)
+			return
+		}
+		fn(path, fi)
+	}
+
+	if !w.recursive {
+		entries, err := os.ReadDir(dir)
+		for _, d := range entries {
+			visit(filepath.Join(dir, d.Name()), d)
+		}
+		return err
+	}
+
+	var walkErr error
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path != dir && os.IsNotExist(err) {
+				// Subdirectory could be removed since its parent read
+				return nil
+			}
+			if walkErr == nil {
+				walkErr = err
+			}
+			return nil
+		}
+		if d.IsDir() && path != dir && w.matcher.isDirExcluded(path) {
+			return filepath.SkipDir
+		}
+		visit(path, d)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return walkErr
+}